@@ -2,6 +2,10 @@ package archiver
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -9,11 +13,14 @@ import (
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
+	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
-	"sync"
+	"time"
 
 	"codeberg.org/tsukinoko-kun/oxipng-go"
 	"github.com/gen2brain/jpegxl"
@@ -41,89 +48,480 @@ func CollectFiles(paths []string) ([]FileInfo, error) {
 	return files, nil
 }
 
-func CreateArchive(password, saltHex, outputFile string, files []FileInfo, compressLevel int, optimizeImages bool, imageQuality float32) error {
+// ArchiveOptions controls how CreateArchive stores files.
+type ArchiveOptions struct {
+	CompressLevel  int
+	OptimizeImages bool
+	ImageQuality   float32
+	// PlainIndex stores file names and sizes unencrypted. They are still
+	// authenticated, but anyone can list them without the password.
+	PlainIndex bool
+	// Padding is applied to every entry before encryption.
+	Padding PaddingPolicy
+	// Cipher defaults to DefaultCipherSuite when zero.
+	Cipher CipherSuite
+	// KDF defaults to DefaultKDFParams when zero.
+	KDF KDFParams
+	// SigningKey, when set, signs the finished archive.
+	SigningKey ed25519.PrivateKey
+	// Reserve is the amount of random free space after the data. Archives
+	// with and without a hidden archive look alike only if both reserve the
+	// same space.
+	Reserve uint64
+	// Hidden, when set, is stored inside the free space and opens with its
+	// own credentials instead of the outer ones. It needs a Reserve large
+	// enough to hold it, so that it never changes the archive size.
+	Hidden *HiddenArchive
+	// Recovery is the size of the Reed-Solomon recovery record in percent
+	// of the archive; zero means none.
+	Recovery float64
+	// VolumeSize, when set, splits the archive into volumes of at most this
+	// many bytes, named outputFile.001, outputFile.002, ...
+	VolumeSize uint64
+	// Overwrite replaces an existing archive instead of failing.
+	Overwrite bool
+	// Creator names the program writing the archive, e.g. "seaf 1.2".
+	// DefaultCreator is used when empty.
+	Creator string
+	// Logger receives warnings and debug details; slog.Default() when nil.
+	Logger *slog.Logger
+}
+
+func (o ArchiveOptions) logger() *slog.Logger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return slog.Default()
+}
+
+// EntryStats reports how one file was stored.
+type EntryStats struct {
+	Entry
+	Path string
+	// CompressedSize is the size after compression, before padding and
+	// encryption. StoredSize is the size after all of them.
+	CompressedSize uint64
+	PaddingSize    uint64
+	// Entropy of the contents from 0 to 1; the higher, the worse they
+	// compress.
+	Entropy float64
+	// Duration is the time taken to read, compress and encrypt the file.
+	Duration time.Duration
+}
+
+// CreateArchive writes a new archive to outputFile, or to a set of volumes
+// if opts.VolumeSize is set. It returns how the files were stored, in input
+// order; the files of a hidden archive are not included.
+func CreateArchive(creds Credentials, saltHex, outputFile string, files []FileInfo, opts ArchiveOptions) ([]EntryStats, error) {
+	if err := creds.Validate(); err != nil {
+		return nil, err
+	}
+
 	salt, err := hex.DecodeString(saltHex)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	target := outputFile
+	if opts.VolumeSize > 0 {
+		target = VolumePath(outputFile, 1)
+	}
+	if !opts.Overwrite {
+		if _, err := os.Lstat(target); err == nil {
+			return nil, fmt.Errorf("%s already exists", target)
+		}
 	}
 
-	key, err := GenerateKey(password, salt)
+	// The archive is written under a temporary name next to outputFile and
+	// renamed when complete, so a failed run neither leaves a truncated
+	// archive behind nor destroys the one it was meant to replace.
+	tmp, err := createTemp(filepath.Dir(outputFile), "."+filepath.Base(outputFile)+".*.tmp")
 	if err != nil {
-		return err
+		return nil, err
+	}
+	tmpName := tmp.Name()
+	var outFile randomAccessFile = tmp
+	var volumes *volumeSet
+	if opts.VolumeSize > 0 {
+		// The empty file only reserves a unique name for the volumes.
+		tmp.Close()
+		defer os.Remove(tmpName)
+		if volumes, err = createVolumes(tmpName, opts.VolumeSize); err != nil {
+			return nil, err
+		}
+		outFile = volumes
 	}
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		outFile.Close()
+		if volumes != nil {
+			removeVolumes(tmpName, len(volumes.files))
+		} else {
+			os.Remove(tmpName)
+		}
+	}()
 
-	outFile, err := os.Create(outputFile)
+	stats, err := writeArchive(outFile, creds, salt, files, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer outFile.Close()
 
-	if err := WriteHeader(outFile, uint32(len(files))); err != nil {
-		return err
+	if opts.Recovery > 0 {
+		if err := WriteRecoveryRecord(outFile, opts.Recovery); err != nil {
+			return nil, err
+		}
+		opts.logger().Debug("added recovery record", "percent", opts.Recovery)
 	}
 
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	sem := make(chan struct{}, runtime.NumCPU())
+	if err := outFile.Sync(); err != nil {
+		return nil, err
+	}
+	if err := outFile.Close(); err != nil {
+		return nil, err
+	}
+	if volumes != nil {
+		err = renameVolumes(tmpName, outputFile, len(volumes.files), opts.Overwrite)
+	} else {
+		err = publish(tmpName, outputFile, opts.Overwrite)
+	}
+	if err != nil {
+		return nil, err
+	}
+	committed = true
+	return stats, nil
+}
 
-	for _, file := range files {
-		wg.Add(1)
-		go func(f FileInfo) {
-			defer wg.Done()
-			sem <- struct{}{}
-			defer func() { <-sem }()
+// createTemp is os.CreateTemp, except that the file gets the permissions of
+// any new file, 0666 less the umask, since it becomes the archive.
+func createTemp(dir, pattern string) (*os.File, error) {
+	prefix, suffix, _ := strings.Cut(pattern, "*")
+	for range 100 {
+		name := filepath.Join(dir, prefix+rand.Text()+suffix)
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, err
+		}
+	}
+	return nil, fmt.Errorf("cannot create a temporary file in %s", dir)
+}
 
-			data, err := os.ReadFile(f.Path)
-			if err != nil {
-				fmt.Printf("Error reading %s: %v\n", f.Path, err)
-				return
-			}
+// publish moves the finished file from to its final name. Unless overwrite
+// is set it links instead of renaming, which fails rather than replaces if
+// the name was taken while the archive was written. Filesystems without hard
+// links fall back to checking first.
+func publish(from, to string, overwrite bool) error {
+	if overwrite {
+		return os.Rename(from, to)
+	}
+	err := os.Link(from, to)
+	switch {
+	case err == nil:
+		return os.Remove(from)
+	case errors.Is(err, fs.ErrExist):
+		return fmt.Errorf("%s already exists", to)
+	}
+	if _, err := os.Lstat(to); err == nil {
+		return fmt.Errorf("%s already exists", to)
+	}
+	return os.Rename(from, to)
+}
 
-			if optimizeImages {
-				optData, changed, optErr := OptimizeImage(data, f.Path, imageQuality)
-				if optErr != nil {
-					fmt.Printf("Warning: could not optimize %s: %v\n", f.Path, optErr)
-				} else if changed {
-					fmt.Printf("Optimized %s: %d bytes -> %d bytes\n",
-						filepath.Base(f.Path), len(data), len(optData))
-					data = optData
-				}
-			}
+// WriteArchive writes a new archive to w, which does not have to be
+// seekable, e.g. a pipe. Recovery records and volumes need CreateArchive.
+func WriteArchive(w io.Writer, creds Credentials, saltHex string, files []FileInfo, opts ArchiveOptions) ([]EntryStats, error) {
+	if err := creds.Validate(); err != nil {
+		return nil, err
+	}
 
-			dataToStore, method, err := PrepareEntryData(data, compressLevel)
-			if err != nil {
-				fmt.Printf("Error preparing %s: %v\n", f.Path, err)
-				return
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Recovery > 0 || opts.VolumeSize > 0 {
+		return nil, errors.New("recovery records and volumes can only be written to files")
+	}
+	return writeArchive(w, creds, salt, files, opts)
+}
+
+func writeArchive(w io.Writer, creds Credentials, salt []byte, files []FileInfo, opts ArchiveOptions) ([]EntryStats, error) {
+	header := &Header{Version: Version, KDF: opts.KDF, Cipher: opts.Cipher}
+	if header.KDF == (KDFParams{}) {
+		header.KDF = DefaultKDFParams
+	}
+	if header.Cipher == 0 {
+		header.Cipher = DefaultCipherSuite()
+	}
+	if err := header.Cipher.Validate(); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(header.ArchiveID[:]); err != nil {
+		return nil, err
+	}
+	if !opts.PlainIndex {
+		header.Flags |= FlagEncryptedIndex
+	}
+	if len(creds.KeyFiles) > 0 {
+		header.Flags |= FlagKeyFiles
+	}
+	if opts.SigningKey != nil {
+		header.Flags |= FlagSigned
+	}
+	if opts.Padding.Mode != PaddingNone {
+		header.Flags |= FlagPadded
+	}
+	meta := newArchiveMeta(opts)
+
+	start := time.Now()
+	masterKey, err := GenerateKey(creds, salt, header.KDF)
+	if err != nil {
+		return nil, err
+	}
+	defer masterKey.Wipe()
+	opts.logger().Debug("derived key", "kdf", header.KDF.String(), "cipher", header.Cipher.String(), "took", time.Since(start))
+	keys, err := DeriveArchiveKeys(masterKey, header)
+	if err != nil {
+		return nil, err
+	}
+	defer keys.Wipe()
+
+	// Everything before the signature section is covered by the signature.
+	archiveDigest := sha256.New()
+	out := io.MultiWriter(w, archiveDigest)
+
+	if err := WriteHeader(out, header); err != nil {
+		return nil, err
+	}
+
+	stats, dataDigest, dataSize, err := writeEntries(out, header, keys, files, opts)
+	if err != nil {
+		return nil, err
+	}
+	entries := statsEntries(stats)
+	indexOffset := headerSize + dataSize
+
+	// Free space between the data and the index holds the hidden archive, if
+	// any, and is otherwise random.
+	slot, err := randomHiddenSlot()
+	if err != nil {
+		return nil, err
+	}
+	var freeSpace uint64
+	if opts.Hidden != nil {
+		if opts.Reserve == 0 {
+			return nil, errors.New("a hidden archive needs reserved free space to hide in")
+		}
+		if err := opts.Hidden.Credentials.Validate(); err != nil {
+			return nil, fmt.Errorf("hidden archive: %v", err)
+		}
+		hiddenKey, err := GenerateKey(opts.Hidden.Credentials, salt, header.KDF)
+		if err != nil {
+			return nil, err
+		}
+		defer hiddenKey.Wipe()
+		if subtle.ConstantTimeCompare(hiddenKey.Bytes(), masterKey.Bytes()) == 1 {
+			return nil, errors.New("the hidden archive needs different credentials than the outer one")
+		}
+		slot, freeSpace, err = writeHiddenArchive(out, header, hiddenKey, indexOffset, opts.Hidden, meta, opts)
+		if err != nil {
+			return nil, fmt.Errorf("hidden archive: %v", err)
+		}
+		// A hidden archive larger than the reserve would show in the size.
+		if freeSpace > opts.Reserve {
+			return nil, fmt.Errorf("hidden archive needs %d bytes, more than the %d reserved", freeSpace, opts.Reserve)
+		}
+	}
+	if opts.Reserve > freeSpace {
+		if _, err := io.CopyN(out, rand.Reader, int64(opts.Reserve-freeSpace)); err != nil {
+			return nil, err
+		}
+		freeSpace = opts.Reserve
+	}
+	indexOffset += freeSpace
+	opts.logger().Debug("wrote data", "entries", len(entries), "free_space", freeSpace, "index_offset", indexOffset)
+
+	section, err := WriteIndex(out, keys, header, entries, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := WriteTrailer(out, keys, header, indexOffset, uint32(len(entries)), dataDigest, sumDigest(section)); err != nil {
+		return nil, err
+	}
+
+	if _, err := out.Write(slot); err != nil {
+		return nil, err
+	}
+
+	if opts.SigningKey != nil {
+		if err := WriteSignature(w, opts.SigningKey, archiveDigest.Sum(nil)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := WriteFooter(w, indexOffset); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// writeEntries prepares the files in parallel and writes their sync prefixes
+// and encrypted data strictly in input order, since each entry's position is
+// part of its associated data. At most GOMAXPROCS entries are in memory at a
+// time: a file is only handed to a worker once the writer is within that many
+// entries of it. It returns the entries with their stats, the digest of the
+// data written and its size.
+func writeEntries(w io.Writer, header *Header, keys *ArchiveKeys, files []FileInfo, opts ArchiveOptions) ([]EntryStats, []byte, uint64, error) {
+	window := runtime.GOMAXPROCS(0)
+	results := make([]chan preparedEntry, len(files))
+	for i := range results {
+		results[i] = make(chan preparedEntry, 1)
+	}
+
+	jobs := make(chan int)
+	for range min(window, len(files)) {
+		go func() {
+			for i := range jobs {
+				results[i] <- prepareEntry(header, uint32(i), files[i], keys, opts)
 			}
+		}()
+	}
 
-			encryptedData, err := Encrypt(dataToStore, key)
-			if err != nil {
-				fmt.Printf("Error encrypting %s: %v\n", f.Path, err)
+	// slots holds one token per entry handed out and not yet written.
+	slots := make(chan struct{}, window)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(jobs)
+		for i := range files {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
 				return
 			}
+			jobs <- i
+		}
+	}()
 
-			mu.Lock()
-			err = WriteFileEntry(outFile, filepath.Base(f.Path), method, encryptedData)
-			mu.Unlock()
-			if err != nil {
-				fmt.Printf("Error writing entry %s: %v\n", f.Path, err)
-			}
-		}(file)
+	dataDigest := sha256.New()
+	data := io.MultiWriter(w, dataDigest)
+	stats := make([]EntryStats, 0, len(files))
+	var dataSize uint64
+
+	for i := range files {
+		prepared := <-results[i]
+		<-slots
+		if prepared.err != nil {
+			return nil, nil, 0, prepared.err
+		}
+		if _, err := data.Write(prepared.syncPrefix); err != nil {
+			return nil, nil, 0, fmt.Errorf("error writing entry %s: %v", files[i].Path, err)
+		}
+		if _, err := data.Write(prepared.encryptedData); err != nil {
+			return nil, nil, 0, fmt.Errorf("error writing entry %s: %v", files[i].Path, err)
+		}
+		stats = append(stats, prepared.stats)
+		entry := prepared.stats.Entry
+		dataSize += uint64(len(prepared.syncPrefix)) + entry.StoredSize
+		opts.logger().Debug("wrote entry", "index", i, "name", entry.Name,
+			"compression", CompressionName(entry.CompressionMethod),
+			"size", entry.OriginalSize, "stored", entry.StoredSize, "took", prepared.stats.Duration)
 	}
 
-	wg.Wait()
+	return stats, dataDigest.Sum(nil), dataSize, nil
+}
 
-	return nil
+// statsEntries returns the entries of stats for the index.
+func statsEntries(stats []EntryStats) []Entry {
+	entries := make([]Entry, len(stats))
+	for i := range stats {
+		entries[i] = stats[i].Entry
+	}
+	return entries
+}
+
+type preparedEntry struct {
+	stats         EntryStats
+	syncPrefix    []byte
+	encryptedData []byte
+	err           error
 }
 
+func prepareEntry(header *Header, index uint32, f FileInfo, keys *ArchiveKeys, opts ArchiveOptions) preparedEntry {
+	start := time.Now()
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return preparedEntry{err: fmt.Errorf("error reading %s: %v", f.Path, err)}
+	}
+
+	if opts.OptimizeImages {
+		optData, changed, optErr := OptimizeImage(data, f.Path, opts.ImageQuality)
+		if optErr != nil {
+			opts.logger().Warn("could not optimize image, storing it unchanged", "file", f.Path, "err", optErr)
+		} else if changed {
+			opts.logger().Debug("optimized image", "file", f.Path, "before", len(data), "after", len(optData))
+			data = optData
+		}
+	}
+
+	dataToStore, method, err := PrepareEntryData(data, opts.CompressLevel)
+	if err != nil {
+		return preparedEntry{err: fmt.Errorf("error preparing %s: %v", f.Path, err)}
+	}
+
+	entry := Entry{
+		Name:              filepath.Base(f.Path),
+		CompressionMethod: method,
+		OriginalSize:      uint64(len(data)),
+	}
+	if header.EncryptedIndex() {
+		entry.Checksum = sha256.Sum256(data)
+	}
+	compressedSize := uint64(len(dataToStore))
+	if header.Padded() {
+		dataToStore = Pad(dataToStore, opts.Padding)
+	}
+
+	entryKey, err := keys.EntryKey(index)
+	if err != nil {
+		return preparedEntry{err: err}
+	}
+	defer wipe(entryKey)
+	encryptedData, err := Encrypt(header.Cipher, dataToStore, entryKey, EntryAAD(header, index, &entry))
+	if err != nil {
+		return preparedEntry{err: fmt.Errorf("error encrypting %s: %v", f.Path, err)}
+	}
+	entry.StoredSize = uint64(len(encryptedData))
+
+	syncPrefix, err := sealSyncPrefix(keys, header, index, &entry, entryKey)
+	if err != nil {
+		return preparedEntry{err: fmt.Errorf("error encrypting %s: %v", f.Path, err)}
+	}
+
+	stats := EntryStats{
+		Entry:          entry,
+		Path:           f.Path,
+		CompressedSize: compressedSize,
+		PaddingSize:    uint64(len(dataToStore)) - compressedSize,
+		Entropy:        entropy(data),
+		Duration:       time.Since(start),
+	}
+	return preparedEntry{stats: stats, syncPrefix: syncPrefix, encryptedData: encryptedData}
+}
+
+// OptimizeImage recompresses PNG losslessly and converts other images to
+// JPEG XL, keeping the result only if it is smaller. Data that is not an
+// image is returned unchanged; an error means the encoder failed.
 func OptimizeImage(originalData []byte, filename string, imageQuality float32) ([]byte, bool, error) {
 	ext := strings.ToLower(filepath.Ext(filename))
 
 	if ext == ".png" {
 		optimized, err := oxipng.Optimize(originalData)
 		if err != nil {
-			fmt.Printf("Warning: oxipng failed for %s: %v\n", filename, err)
-			return originalData, false, nil
+			return originalData, false, fmt.Errorf("oxipng failed: %v", err)
 		}
 		if len(optimized) < len(originalData) {
 			return optimized, true, nil
@@ -154,8 +552,7 @@ func OptimizeImage(originalData []byte, filename string, imageQuality float32) (
 	}
 
 	if err := jpegxl.Encode(&buf, img, opts); err != nil {
-		fmt.Printf("Warning: JPEG XL encoding failed for %s: %v\n", filename, err)
-		return originalData, false, nil
+		return originalData, false, fmt.Errorf("JPEG XL encoding failed: %v", err)
 	}
 
 	jxlData := buf.Bytes()
@@ -0,0 +1,225 @@
+package archiver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCreateArchiveOrder(t *testing.T) {
+	// More files than workers, so that they finish out of order.
+	contents := make([][]byte, 3*runtime.GOMAXPROCS(0)+1)
+	for i := range contents {
+		contents[i] = randomBytes(t, 100+(len(contents)-i)*500)
+	}
+	archive := createTestArchiveFiles(t, contents, ArchiveOptions{})
+
+	index, err := ListArchive(testCredentials(), testSalt, archive)
+	if err != nil {
+		t.Fatalf("ListArchive: %v", err)
+	}
+	if len(index.Entries) != len(contents) {
+		t.Fatalf("got %d entries, want %d", len(index.Entries), len(contents))
+	}
+	for i, e := range index.Entries {
+		if want := fmt.Sprintf("file%d.bin", i); e.Name != want {
+			t.Errorf("entry %d is %s, want %s", i, e.Name, want)
+		}
+	}
+	if _, err := ExtractArchive(testCredentials(), testSalt, archive, t.TempDir()); err != nil {
+		t.Fatalf("ExtractArchive: %v", err)
+	}
+}
+
+// TestPlainIndexTampering edits the unencrypted index, which anyone can
+// read, and checks that the trailer still catches it.
+func TestPlainIndexTampering(t *testing.T) {
+	contents := [][]byte{randomBytes(t, 1000), randomBytes(t, 1000), randomBytes(t, 1000)}
+	archive := createTestArchiveFiles(t, contents, ArchiveOptions{PlainIndex: true})
+	original, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ListArchive(testCredentials(), testSalt, archive); err != nil {
+		t.Fatalf("ListArchive(untouched): %v", err)
+	}
+
+	// The index section is a length, the entry count and the entries, all
+	// of the same size here since the names and sizes match.
+	indexOffset := int(binary.BigEndian.Uint64(original[len(original)-footerSize:]))
+	sectionLen := int(binary.BigEndian.Uint32(original[indexOffset:]))
+	entriesStart := indexOffset + 8
+	entrySize := encodedEntrySize(&Entry{Name: "file0.bin"})
+	entry := func(b []byte, i int) []byte {
+		return b[entriesStart+i*entrySize : entriesStart+(i+1)*entrySize]
+	}
+	if name := string(entry(original, 1)[2 : 2+9]); name != "file1.bin" {
+		t.Fatalf("second index entry is %q, want file1.bin", name)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(b []byte) []byte
+	}{
+		{"renamed", func(b []byte) []byte {
+			copy(entry(b, 1)[2:], "fileX.bin")
+			return b
+		}},
+		{"resized", func(b []byte) []byte {
+			entry(b, 0)[2+9+1+7]++
+			return b
+		}},
+		{"reordered", func(b []byte) []byte {
+			first := bytes.Clone(entry(b, 0))
+			copy(entry(b, 0), entry(b, 1))
+			copy(entry(b, 1), first)
+			return b
+		}},
+		{"dropped", func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[indexOffset:], uint32(sectionLen-entrySize))
+			binary.BigEndian.PutUint32(b[indexOffset+4:], 2)
+			last := entry(b, 2)
+			return append(b[:entriesStart+2*entrySize], b[entriesStart+2*entrySize+len(last):]...)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(archive, tt.modify(bytes.Clone(original)), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ListArchive(testCredentials(), testSalt, archive); !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("ListArchive = %v, want %v", err, ErrCorrupt)
+			}
+		})
+	}
+
+	for _, size := range []int{headerSize + 10, len(original) / 2, indexOffset, len(original) - 1} {
+		t.Run(fmt.Sprintf("truncated to %d", size), func(t *testing.T) {
+			if err := os.WriteFile(archive, original[:size], 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ExtractArchive(testCredentials(), testSalt, archive, t.TempDir()); !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("ExtractArchive = %v, want %v", err, ErrCorrupt)
+			}
+		})
+	}
+}
+
+func TestCreateArchivePublish(t *testing.T) {
+	for _, volumeSize := range []uint64{0, 64 * 1024} {
+		t.Run(fmt.Sprintf("volume size %d", volumeSize), func(t *testing.T) {
+			opts := ArchiveOptions{KDF: testKDF, VolumeSize: volumeSize}
+			data := randomBytes(t, 100*1024)
+			archive := createTestArchive(t, data, opts)
+			target := archive
+			if volumeSize > 0 {
+				target = VolumePath(archive, 1)
+			}
+			before, err := os.ReadFile(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// New archives get the same permissions as any other new file.
+			ref, err := os.Create(filepath.Join(t.TempDir(), "ref"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ref.Close()
+			refInfo, err := os.Stat(ref.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode() != refInfo.Mode() {
+				t.Errorf("archive mode is %v, want %v", info.Mode(), refInfo.Mode())
+			}
+
+			files, err := CollectFiles([]string{filepath.Join(filepath.Dir(archive), "input.bin")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := CreateArchive(testCredentials(), testSalt, archive, files, opts); err == nil {
+				t.Fatal("CreateArchive replaced an existing archive without Overwrite")
+			}
+			after, err := os.ReadFile(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(before, after) {
+				t.Fatal("existing archive was modified")
+			}
+			entries, err := os.ReadDir(filepath.Dir(archive))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if strings.HasSuffix(e.Name(), ".tmp") || strings.Contains(e.Name(), ".tmp.") {
+					t.Errorf("temporary file %s was left behind", e.Name())
+				}
+			}
+
+			opts.Overwrite = true
+			if _, err := CreateArchive(testCredentials(), testSalt, archive, files, opts); err != nil {
+				t.Fatalf("CreateArchive(Overwrite): %v", err)
+			}
+			checkExtract(t, archive, data)
+		})
+	}
+}
+
+// TestPublishExisting covers an archive appearing under the output name
+// while the new one is written.
+func TestPublishExisting(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	check := func(path, want string) {
+		t.Helper()
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", filepath.Base(path), got, err, want)
+		}
+	}
+
+	tmp := write("new.tmp", "new")
+	target := write("archive.seaf", "old")
+	if err := publish(tmp, target, false); err == nil {
+		t.Fatal("publish replaced an existing file")
+	}
+	check(target, "old")
+	check(tmp, "new")
+	if err := publish(tmp, target, true); err != nil {
+		t.Fatalf("publish(overwrite): %v", err)
+	}
+	check(target, "new")
+
+	// A set is published completely or not at all.
+	tmp = filepath.Join(dir, "set.tmp")
+	set := filepath.Join(dir, "set.seaf")
+	write(filepath.Base(VolumePath(tmp, 1)), "new 1")
+	write(filepath.Base(VolumePath(tmp, 2)), "new 2")
+	write(filepath.Base(VolumePath(set, 2)), "old 2")
+	if err := renameVolumes(tmp, set, 2, false); err == nil {
+		t.Fatal("renameVolumes replaced an existing volume")
+	}
+	if _, err := os.Stat(VolumePath(set, 1)); !os.IsNotExist(err) {
+		t.Errorf("volume 1 was left published: %v", err)
+	}
+	check(VolumePath(tmp, 1), "new 1")
+	check(VolumePath(set, 2), "old 2")
+}
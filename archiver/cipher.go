@@ -0,0 +1,105 @@
+package archiver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/sys/cpu"
+)
+
+// CipherSuite selects the AEAD used for every sealed part of an archive. It is
+// recorded in the header.
+type CipherSuite uint8
+
+const (
+	CipherAES256GCM         CipherSuite = 1
+	CipherXChaCha20Poly1305 CipherSuite = 2
+)
+
+func (c CipherSuite) String() string {
+	switch c {
+	case CipherAES256GCM:
+		return "aes-256-gcm"
+	case CipherXChaCha20Poly1305:
+		return "xchacha20-poly1305"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(c))
+	}
+}
+
+func (c CipherSuite) Validate() error {
+	switch c {
+	case CipherAES256GCM, CipherXChaCha20Poly1305:
+		return nil
+	default:
+		return errorf(ErrUnsupportedVersion, "unsupported cipher suite: %d", uint8(c))
+	}
+}
+
+// ParseCipherSuite accepts the names printed by String, plus "auto" which
+// picks the fastest suite for this machine.
+func ParseCipherSuite(name string) (CipherSuite, error) {
+	switch strings.ToLower(name) {
+	case "", "auto":
+		return DefaultCipherSuite(), nil
+	case "aes", "aes-gcm", "aes-256-gcm":
+		return CipherAES256GCM, nil
+	case "xchacha", "xchacha20", "xchacha20-poly1305":
+		return CipherXChaCha20Poly1305, nil
+	default:
+		return 0, fmt.Errorf("unknown cipher %q (use auto, aes-256-gcm or xchacha20-poly1305)", name)
+	}
+}
+
+// DefaultCipherSuite prefers AES-256-GCM when the CPU accelerates it and
+// XChaCha20-Poly1305 otherwise, which is both faster in software and safe
+// with random nonces for any archive size.
+func DefaultCipherSuite() CipherSuite {
+	if hasAESGCMHardware() {
+		return CipherAES256GCM
+	}
+	return CipherXChaCha20Poly1305
+}
+
+func hasAESGCMHardware() bool {
+	switch runtime.GOARCH {
+	case "amd64", "386":
+		return cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ
+	case "arm64":
+		return cpu.ARM64.HasAES && cpu.ARM64.HasPMULL
+	case "s390x":
+		return cpu.S390X.HasAES && cpu.S390X.HasAESGCM
+	case "ppc64", "ppc64le":
+		return true
+	default:
+		return false
+	}
+}
+
+func (c CipherSuite) aead(key []byte) (cipher.AEAD, error) {
+	switch c {
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, c.Validate()
+	}
+}
+
+// overhead is the number of bytes Encrypt adds to the plaintext.
+func (c CipherSuite) overhead() (int, error) {
+	aead, err := c.aead(make([]byte, keySize))
+	if err != nil {
+		return 0, err
+	}
+	return aead.NonceSize() + aead.Overhead(), nil
+}
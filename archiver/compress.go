@@ -5,6 +5,7 @@ import (
 	"compress/flate"
 	"fmt"
 	"io"
+	"math"
 )
 
 func Compress(data []byte, level int) ([]byte, error) {
@@ -42,3 +43,24 @@ func Decompress(data []byte) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// entropy returns the Shannon entropy of data per byte, scaled to 0-1.
+func entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var freq [256]int
+	for _, b := range data {
+		freq[b]++
+	}
+
+	var e float64
+	for _, count := range freq {
+		if count > 0 {
+			p := float64(count) / float64(len(data))
+			e -= p * math.Log2(p)
+		}
+	}
+	return e / 8
+}
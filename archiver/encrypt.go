@@ -1,38 +1,70 @@
 package archiver
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/rand"
 	"io"
 
 	"golang.org/x/crypto/scrypt"
 )
 
-func GenerateKey(password string, salt []byte) ([]byte, error) {
-	key, err := scrypt.Key([]byte(password), salt, 32768, 8, 1, 32)
+const KDFScrypt = 1
+
+// KDFParams are the key derivation settings recorded in the archive header.
+// LogN is the base-2 logarithm of the scrypt cost parameter.
+type KDFParams struct {
+	Algorithm uint8
+	LogN      uint8
+	R         uint8
+	P         uint8
+}
+
+var DefaultKDFParams = KDFParams{Algorithm: KDFScrypt, LogN: 15, R: 8, P: 1}
+
+func (p KDFParams) Validate() error {
+	if p.Algorithm != KDFScrypt {
+		return errorf(ErrUnsupportedVersion, "unsupported key derivation function: %d", p.Algorithm)
+	}
+	if p.LogN < 10 || p.LogN > 30 || p.R == 0 || p.P == 0 {
+		return errorf(ErrUnsupportedVersion, "invalid scrypt parameters: N=2^%d r=%d p=%d", p.LogN, p.R, p.P)
+	}
+	// The parameters come from the archive header, so a crafted header
+	// must not make the key derivation exhaust the memory.
+	if p.Memory() > DefaultKDFMaxMemory {
+		return errorf(ErrUnsupportedVersion, "key derivation needs %d bytes of memory, more than the limit of %d", p.Memory(), DefaultKDFMaxMemory)
+	}
+	return nil
+}
+
+// GenerateKey derives the master key from the credentials. The caller must
+// Wipe it once done.
+func GenerateKey(creds Credentials, salt []byte, params KDFParams) (*Key, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	secret, err := creds.secret()
 	if err != nil {
 		return nil, err
 	}
-	return key, nil
-}
+	defer wipe(secret)
 
-func Encrypt(data []byte, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
+	key, err := scrypt.Key(secret, salt, 1<<params.LogN, int(params.R), int(params.P), keySize)
 	if err != nil {
 		return nil, err
 	}
+	return &Key{b: key}, nil
+}
 
-	aesGCM, err := cipher.NewGCM(block)
+func Encrypt(suite CipherSuite, data []byte, key []byte, additionalData []byte) ([]byte, error) {
+	aead, err := suite.aead(key)
 	if err != nil {
 		return nil, err
 	}
 
-	nonce := make([]byte, aesGCM.NonceSize())
+	nonce := make([]byte, aead.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
 
-	ciphertext := aesGCM.Seal(nonce, nonce, data, nil)
+	ciphertext := aead.Seal(nonce, nonce, data, additionalData)
 	return ciphertext, nil
 }
@@ -0,0 +1,45 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the package match one of these with errors.Is when the
+// cause is known. They carry their own, more specific message.
+var (
+	// ErrWrongPassword means the credentials don't open the archive. A
+	// damaged trailer can't be told apart from a wrong password.
+	ErrWrongPassword = errors.New("wrong password or key files")
+	// ErrCorrupt means the archive or an entry failed a check.
+	ErrCorrupt = errors.New("archive is corrupted")
+	// ErrUnsupportedVersion means the archive uses a format version or
+	// features this version does not know.
+	ErrUnsupportedVersion = errors.New("unsupported archive version")
+	// ErrNotArchive means the file is not a seaf archive.
+	ErrNotArchive = errors.New("not an archive")
+	// ErrUnsafePath means an entry name would be written outside the
+	// output directory.
+	ErrUnsafePath = errors.New("unsafe entry name")
+	// ErrBadSignature means the archive is unsigned, signed by another key
+	// or its signature does not verify.
+	ErrBadSignature = errors.New("bad signature")
+)
+
+// archiveError is an error with its own message that matches kind.
+type archiveError struct {
+	kind error
+	msg  string
+}
+
+func (e *archiveError) Error() string {
+	return e.msg
+}
+
+func (e *archiveError) Is(target error) bool {
+	return target == e.kind
+}
+
+func errorf(kind error, format string, args ...any) error {
+	return &archiveError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,124 @@
+package archiver
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSalt = "00112233445566778899aabbccddeeff"
+
+// testKDF keeps the key derivation cheap in tests.
+var testKDF = KDFParams{Algorithm: KDFScrypt, LogN: 10, R: 8, P: 1}
+
+func testCredentials() Credentials {
+	return Credentials{Password: []byte("correct horse battery staple")}
+}
+
+// createTestArchive archives one file with the given contents and returns the
+// archive path.
+func createTestArchive(t *testing.T, data []byte, opts ArchiveOptions) string {
+	t.Helper()
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.bin")
+	if err := os.WriteFile(input, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return archiveTestFiles(t, dir, []string{input}, opts)
+}
+
+// createTestArchiveFiles archives one file per element of contents, named
+// file0.bin, file1.bin, ..., and returns the archive path.
+func createTestArchiveFiles(t *testing.T, contents [][]byte, opts ArchiveOptions) string {
+	t.Helper()
+	dir := t.TempDir()
+	var inputs []string
+	for i, data := range contents {
+		input := filepath.Join(dir, fmt.Sprintf("file%d.bin", i))
+		if err := os.WriteFile(input, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, input)
+	}
+	return archiveTestFiles(t, dir, inputs, opts)
+}
+
+func archiveTestFiles(t *testing.T, dir string, inputs []string, opts ArchiveOptions) string {
+	t.Helper()
+	files, err := CollectFiles(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.KDF == (KDFParams{}) {
+		opts.KDF = testKDF
+	}
+	archive := filepath.Join(dir, "test.seaf")
+	if _, err := CreateArchive(testCredentials(), testSalt, archive, files, opts); err != nil {
+		t.Fatalf("CreateArchive: %v", err)
+	}
+	return archive
+}
+
+func TestReadHeaderErrors(t *testing.T) {
+	valid := (&Header{Version: Version, KDF: testKDF, Cipher: CipherAES256GCM}).Bytes()
+	tests := []struct {
+		name   string
+		modify func(b []byte) []byte
+		want   error
+	}{
+		{"too short", func(b []byte) []byte { return b[:10] }, ErrNotArchive},
+		{"bad magic", func(b []byte) []byte { b[0] ^= 0xff; return b }, ErrNotArchive},
+		{"version", func(b []byte) []byte { b[5] = Version + 1; return b }, ErrUnsupportedVersion},
+		{"flags", func(b []byte) []byte { b[6] = 0x80; return b }, ErrUnsupportedVersion},
+		{"kdf algorithm", func(b []byte) []byte { b[8] = 9; return b }, ErrUnsupportedVersion},
+		{"kdf parameters", func(b []byte) []byte { b[10] = 0; return b }, ErrUnsupportedVersion},
+		{"kdf memory", func(b []byte) []byte { b[9] = 30; b[10] = 255; b[11] = 255; return b }, ErrUnsupportedVersion},
+		{"cipher", func(b []byte) []byte { b[12] = 99; return b }, ErrUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.modify(bytes.Clone(valid))
+			_, err := ReadHeader(bytes.NewReader(b))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("ReadHeader() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := ReadHeader(bytes.NewReader(valid)); err != nil {
+		t.Fatalf("ReadHeader(valid) = %v", err)
+	}
+}
+
+func TestArchiveErrors(t *testing.T) {
+	archive := createTestArchive(t, bytes.Repeat([]byte("seaf "), 1000), ArchiveOptions{})
+
+	wrong := Credentials{Password: []byte("wrong password")}
+	if _, err := ListArchive(wrong, testSalt, archive); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("ListArchive(wrong password) = %v, want %v", err, ErrWrongPassword)
+	}
+
+	if err := VerifySignature(archive, make(ed25519.PublicKey, ed25519.PublicKeySize)); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("VerifySignature(unsigned) = %v, want %v", err, ErrBadSignature)
+	}
+
+	if err := checkEntryName("../escape.txt"); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("checkEntryName(../escape.txt) = %v, want %v", err, ErrUnsafePath)
+	}
+
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[headerSize+100] ^= 0xff
+	if err := os.WriteFile(archive, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExtractArchive(testCredentials(), testSalt, archive, t.TempDir()); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("ExtractArchive(corrupted) = %v, want %v", err, ErrCorrupt)
+	}
+}
@@ -1,120 +1,364 @@
 package archiver
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"encoding/binary"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-func ExtractArchive(password, saltHex, archiveFile, outputDir string) error {
-	salt, err := hex.DecodeString(saltHex)
-	if err != nil {
-		return err
+// ExtractArchive writes every entry into outputDir and returns the index of
+// the extracted archive.
+func ExtractArchive(creds Credentials, saltHex, archiveFile, outputDir string) (*Index, error) {
+	if err := creds.Validate(); err != nil {
+		return nil, err
 	}
 
-	key, err := GenerateKey(password, salt)
+	salt, err := hex.DecodeString(saltHex)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	inFile, err := os.Open(archiveFile)
+	inFile, err := openArchiveFile(archiveFile, os.O_RDONLY)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer inFile.Close()
 
-	totalFiles, err := ReadHeader(inFile)
+	index, keys, err := OpenArchive(inFile, creds, salt)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer keys.Wipe()
+
+	// Nothing is written if any name is unsafe.
+	for i := range index.Entries {
+		if err := checkEntryName(index.Entries[i].Name); err != nil {
+			return nil, &EntryError{Index: i, Name: index.Entries[i].Name, Err: err}
+		}
 	}
 
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %v", err)
+		return nil, fmt.Errorf("failed to create output directory: %v", err)
 	}
 
-	for i := uint32(0); i < totalFiles; i++ {
-		var nameLen uint16
-		err := binary.Read(inFile, binary.BigEndian, &nameLen)
+	err = walkEntries(inFile, index, keys, func(i int, entry *Entry, data []byte, err error) error {
 		if err != nil {
 			return err
 		}
+		outputPath := filepath.Join(outputDir, entry.Name)
 
-		nameBytes := make([]byte, nameLen)
-		if _, err := io.ReadFull(inFile, nameBytes); err != nil {
-			return err
+		if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %v", entry.Name, err)
 		}
-		filename := string(nameBytes)
 
-		var compressionMethod uint8
-		if err := binary.Read(inFile, binary.BigEndian, &compressionMethod); err != nil {
-			return err
+		if err := os.WriteFile(outputPath, data, 0644); err != nil {
+			return fmt.Errorf("failed to write file %s: %v", outputPath, err)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// checkEntryName rejects names that would be written outside the output
+// directory. seaf only stores base names, so a name with a separator or a
+// parent reference comes from a crafted archive.
+func checkEntryName(name string) error {
+	if name == "." || strings.ContainsAny(name, `/\`+"\x00") || !filepath.IsLocal(name) {
+		return errorf(ErrUnsafePath, "unsafe name, it would be written outside the output directory")
+	}
+	return nil
+}
+
+// EntryError reports an entry that failed to decrypt or decompress.
+type EntryError struct {
+	Index int
+	Name  string
+	Err   error
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("entry %d (%s): %v", e.Index, e.Name, e.Err)
+}
+
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
+
+// walkEntries decrypts and restores every entry in order and passes the
+// result to fn, along with an *EntryError if the entry is bad. Returning an
+// error from fn stops the walk. Once all entries are read, the data digest
+// recorded in the trailer is checked.
+func walkEntries(r io.ReadSeeker, index *Index, keys *ArchiveKeys, fn func(i int, entry *Entry, data []byte, err error) error) error {
+	if _, err := r.Seek(int64(index.DataOffset), io.SeekStart); err != nil {
+		return err
+	}
+	digest := sha256.New()
+	data := io.TeeReader(r, digest)
+
+	for i := range index.Entries {
+		entry := &index.Entries[i]
 
-		var encryptedDataLen uint32
-		if err := binary.Read(inFile, binary.BigEndian, &encryptedDataLen); err != nil {
+		prefixSize, err := syncPrefixSize(index.Header, entry)
+		if err != nil {
+			return err
+		}
+		if _, err := io.CopyN(io.Discard, data, int64(prefixSize)); err != nil {
 			return err
 		}
 
-		encryptedData := make([]byte, encryptedDataLen)
-		if _, err := io.ReadFull(inFile, encryptedData); err != nil {
+		encryptedData := make([]byte, entry.StoredSize)
+		if _, err := io.ReadFull(data, encryptedData); err != nil {
 			return err
 		}
 
-		decryptedData, err := Decrypt(encryptedData, key)
+		originalData, err := restoreEntry(index.Header, keys, uint32(i), entry, encryptedData)
 		if err != nil {
+			err = &EntryError{Index: i, Name: entry.Name, Err: err}
+		}
+		if err := fn(i, entry, originalData, err); err != nil {
 			return err
 		}
+	}
+
+	return index.VerifyData(digest.Sum(nil))
+}
+
+func restoreEntry(h *Header, keys *ArchiveKeys, i uint32, entry *Entry, encryptedData []byte) ([]byte, error) {
+	entryKey, err := keys.EntryKey(i)
+	if err != nil {
+		return nil, err
+	}
+	decryptedData, err := Decrypt(h.Cipher, encryptedData, entryKey, EntryAAD(h, i, entry))
+	wipe(entryKey)
+	if err != nil {
+		return nil, errorf(ErrCorrupt, "failed authentication: %v", err)
+	}
+	return RestoreEntryData(h, decryptedData, entry)
+}
+
+// TestReport is the result of TestArchive.
+type TestReport struct {
+	Entries []Entry
+	// Bad lists the entries that failed, in archive order.
+	Bad []*EntryError
+	// Err is set if the archive as a whole failed, e.g. because it is
+	// truncated or its data digest does not match the trailer.
+	Err error
+}
+
+// OK reports whether every check passed.
+func (t *TestReport) OK() bool {
+	return len(t.Bad) == 0 && t.Err == nil
+}
 
-		var originalData []byte
-		switch compressionMethod {
-		case CompressionNone:
-			originalData = decryptedData
-		case CompressionDeflate:
-			originalData, err = Decompress(decryptedData)
-			if err != nil {
-				return err
-			}
-		default:
-			return fmt.Errorf("unknown compression method: %d", compressionMethod)
+// TestArchive runs the full extraction pipeline without writing anything,
+// and unlike ExtractArchive carries on past bad entries so all of them are
+// reported. The error is set only if the archive could not be opened.
+func TestArchive(creds Credentials, saltHex, archiveFile string) (*TestReport, error) {
+	if err := creds.Validate(); err != nil {
+		return nil, err
+	}
+
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return nil, err
+	}
+
+	inFile, err := openArchiveFile(archiveFile, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer inFile.Close()
+
+	index, keys, err := OpenArchive(inFile, creds, salt)
+	if err != nil {
+		return nil, err
+	}
+	defer keys.Wipe()
+
+	report := &TestReport{Entries: index.Entries}
+	report.Err = walkEntries(inFile, index, keys, func(i int, entry *Entry, data []byte, err error) error {
+		var entryErr *EntryError
+		if errors.As(err, &entryErr) {
+			report.Bad = append(report.Bad, entryErr)
 		}
-		outputPath := filepath.Join(outputDir, filename)
+		return nil
+	})
+	return report, nil
+}
 
-		if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
-			return fmt.Errorf("failed to create directory for %s: %v", filename, err)
+// ListArchive returns the authenticated index without reading any entry data.
+func ListArchive(creds Credentials, saltHex, archiveFile string) (*Index, error) {
+	if err := creds.Validate(); err != nil {
+		return nil, err
+	}
+
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return nil, err
+	}
+
+	inFile, err := openArchiveFile(archiveFile, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer inFile.Close()
+
+	index, keys, err := OpenArchive(inFile, creds, salt)
+	if err != nil {
+		return nil, err
+	}
+	keys.Wipe()
+	return index, nil
+}
+
+// ExtractEntry decrypts the entry called name and writes it to w, reading
+// only that entry's data.
+func ExtractEntry(creds Credentials, saltHex, archiveFile, name string, w io.Writer) error {
+	if err := creds.Validate(); err != nil {
+		return err
+	}
+
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return err
+	}
+
+	inFile, err := openArchiveFile(archiveFile, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	index, keys, err := OpenArchive(inFile, creds, salt)
+	if err != nil {
+		return err
+	}
+	defer keys.Wipe()
+
+	offset := index.DataOffset
+	for i := range index.Entries {
+		entry := &index.Entries[i]
+		prefixSize, err := syncPrefixSize(index.Header, entry)
+		if err != nil {
+			return err
+		}
+		offset += prefixSize
+		if entry.Name != name {
+			offset += entry.StoredSize
+			continue
 		}
 
-		if err := os.WriteFile(outputPath, originalData, 0644); err != nil {
-			return fmt.Errorf("failed to write file %s: %v", outputPath, err)
+		encryptedData := make([]byte, entry.StoredSize)
+		if _, err := inFile.ReadAt(encryptedData, int64(offset)); err != nil {
+			return err
 		}
+		data, err := restoreEntry(index.Header, keys, uint32(i), entry, encryptedData)
+		if err != nil {
+			return &EntryError{Index: i, Name: entry.Name, Err: err}
+		}
+		_, err = w.Write(data)
+		return err
 	}
-
-	return nil
+	return fmt.Errorf("no entry named %q in the archive", name)
 }
 
-func Decrypt(ciphertext []byte, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
+// OpenArchive reads the header, derives the keys and returns the
+// authenticated index. Credentials that do not open the archive itself are
+// tried on its hidden slot, so a hidden archive opens the same way. The
+// caller must Wipe the returned keys.
+func OpenArchive(r io.ReadSeeker, creds Credentials, salt []byte) (*Index, *ArchiveKeys, error) {
+	header, err := ReadHeader(r)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	masterKey, err := GenerateKey(creds, salt, header.KDF)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer masterKey.Wipe()
+	keys, err := DeriveArchiveKeys(masterKey, header)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	index, err := ReadIndex(r, keys, header)
+	if err == nil {
+		return index, keys, nil
 	}
+	keys.Wipe()
+	if !errors.Is(err, errTrailerAuth) {
+		return nil, nil, err
+	}
+
+	index, keys, hiddenErr := readHiddenIndex(r, masterKey, header)
+	if hiddenErr == nil {
+		return index, keys, nil
+	}
+	if !errors.Is(hiddenErr, errTrailerAuth) {
+		return nil, nil, hiddenErr
+	}
+	if credErr := header.CheckCredentials(creds); credErr != nil {
+		return nil, nil, credErr
+	}
+	return nil, nil, err
+}
+
+// RestoreEntryData strips padding and reverses PrepareEntryData for a
+// decrypted entry, then checks the result against the recorded checksum.
+func RestoreEntryData(h *Header, data []byte, entry *Entry) ([]byte, error) {
+	if h.Padded() {
+		var err error
+		if data, err = Unpad(data); err != nil {
+			return nil, errorf(ErrCorrupt, "%v", err)
+		}
+	}
+
+	var originalData []byte
+	switch entry.CompressionMethod {
+	case CompressionNone:
+		originalData = data
+	case CompressionDeflate:
+		var err error
+		originalData, err = Decompress(data)
+		if err != nil {
+			return nil, errorf(ErrCorrupt, "%v", err)
+		}
+	default:
+		return nil, errorf(ErrUnsupportedVersion, "unknown compression method: %d", entry.CompressionMethod)
+	}
+
+	if uint64(len(originalData)) != entry.OriginalSize {
+		return nil, errorf(ErrCorrupt, "size mismatch: expected %d bytes, got %d", entry.OriginalSize, len(originalData))
+	}
+	if entry.HasChecksum() && sha256.Sum256(originalData) != entry.Checksum {
+		return nil, errorf(ErrCorrupt, "checksum mismatch: restored data differs from the original")
+	}
+	return originalData, nil
+}
 
-	aesGCM, err := cipher.NewGCM(block)
+func Decrypt(suite CipherSuite, ciphertext []byte, key []byte, additionalData []byte) ([]byte, error) {
+	aead, err := suite.aead(key)
 	if err != nil {
 		return nil, err
 	}
 
-	nonceSize := aesGCM.NonceSize()
+	nonceSize := aead.NonceSize()
 	if len(ciphertext) < nonceSize {
 		return nil, errors.New("incorrect ciphertext")
 	}
 
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
 		return nil, err
 	}
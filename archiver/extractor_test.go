@@ -0,0 +1,73 @@
+package archiver
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestChecksumMismatch rewrites the index with a wrong checksum for the
+// second entry. Everything still authenticates, so only the checksum of the
+// restored data catches it.
+func TestChecksumMismatch(t *testing.T) {
+	contents := [][]byte{randomBytes(t, 5000), bytes.Repeat([]byte("seaf "), 1000)}
+	archive := createTestArchiveFiles(t, contents, ArchiveOptions{})
+
+	f, err := os.OpenFile(archive, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt, err := hex.DecodeString(testSalt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, keys, err := OpenArchive(f, testCredentials(), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !index.Entries[1].HasChecksum() {
+		t.Fatal("entry has no checksum")
+	}
+	index.Entries[1].Checksum[0] ^= 1
+	var buf bytes.Buffer
+	section, err := WriteIndex(&buf, keys, index.Header, index.Entries, index.Meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WriteTrailer(&buf, keys, index.Header, index.IndexOffset, uint32(len(index.Entries)), index.dataDigest, sumDigest(section))
+	keys.Wipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(buf.Bytes(), int64(index.IndexOffset)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := TestArchive(testCredentials(), testSalt, archive)
+	if err != nil {
+		t.Fatalf("TestArchive: %v", err)
+	}
+	if report.Err != nil || len(report.Bad) != 1 || report.Bad[0].Index != 1 || report.Bad[0].Name != "file1.bin" {
+		t.Fatalf("TestArchive = %+v, want entry 1 (file1.bin) bad", report)
+	}
+	if bad := report.Bad[0]; !errors.Is(bad, ErrCorrupt) || !strings.Contains(bad.Error(), "checksum mismatch") {
+		t.Fatalf("TestArchive reported %v, want a checksum mismatch", bad)
+	}
+
+	if _, err := ExtractArchive(testCredentials(), testSalt, archive, t.TempDir()); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("ExtractArchive = %v, want %v", err, ErrCorrupt)
+	}
+	if err := ExtractEntry(testCredentials(), testSalt, archive, "file0.bin", io.Discard); err != nil {
+		t.Fatalf("ExtractEntry(file0.bin): %v", err)
+	}
+	if err := ExtractEntry(testCredentials(), testSalt, archive, "file1.bin", io.Discard); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("ExtractEntry(file1.bin) = %v, want %v", err, ErrCorrupt)
+	}
+}
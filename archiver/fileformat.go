@@ -1,80 +1,609 @@
 package archiver
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"time"
 )
 
 const (
 	MagicNumber        = 0x53454146
-	Version            = 1
+	Version            = 2
 	CompressionDeflate = 6
 	CompressionNone    = 0
 	// I will add other compression methods in the future
+
+	// TrailerMagic marks the authenticated record that closes every archive.
+	TrailerMagic = 0x454E4421
+
+	// FlagEncryptedIndex means file names, sizes and other entry metadata are
+	// only stored inside the encrypted index.
+	FlagEncryptedIndex = 1 << 0
+	// FlagKeyFiles means the key was derived with one or more key files.
+	FlagKeyFiles = 1 << 1
+	// FlagSigned means an Ed25519 signature precedes the footer.
+	FlagSigned = 1 << 2
+	// FlagPadded means entry data was padded with Pad before encryption.
+	FlagPadded = 1 << 3
+
+	knownFlags = FlagEncryptedIndex | FlagKeyFiles | FlagSigned | FlagPadded
+
+	headerSize = 29
+	footerSize = 12
+	digestSize = sha256.Size
 )
 
-func WriteHeader(w io.Writer, totalFiles uint32) error {
-	if err := binary.Write(w, binary.BigEndian, uint32(MagicNumber)); err != nil {
-		return err
-	}
-	if err := binary.Write(w, binary.BigEndian, uint16(Version)); err != nil {
-		return err
+// Header is the plaintext part at the start of an archive. Its encoded form is
+// bound into every entry, the index and the trailer as associated data, so any
+// change to it makes decryption fail.
+type Header struct {
+	Version uint16
+	Flags   uint16
+	KDF     KDFParams
+	Cipher  CipherSuite
+	// ArchiveID is random per archive and salts the subkey derivation.
+	ArchiveID [16]byte
+}
+
+// Bytes returns the header exactly as it is stored in the archive.
+func (h *Header) Bytes() []byte {
+	buf := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(buf[0:4], MagicNumber)
+	binary.BigEndian.PutUint16(buf[4:6], h.Version)
+	binary.BigEndian.PutUint16(buf[6:8], h.Flags)
+	buf[8] = h.KDF.Algorithm
+	buf[9] = h.KDF.LogN
+	buf[10] = h.KDF.R
+	buf[11] = h.KDF.P
+	buf[12] = byte(h.Cipher)
+	copy(buf[13:29], h.ArchiveID[:])
+	return buf
+}
+
+// CheckCredentials reports a clear error when the archive needs key files
+// that were not given, or the other way round.
+func (h *Header) CheckCredentials(creds Credentials) error {
+	needsKeyFiles := h.KeyFiles()
+	if needsKeyFiles && len(creds.KeyFiles) == 0 {
+		return errorf(ErrWrongPassword, "this archive requires a key file")
 	}
-	if err := binary.Write(w, binary.BigEndian, totalFiles); err != nil {
-		return err
+	if !needsKeyFiles && len(creds.KeyFiles) > 0 {
+		return errorf(ErrWrongPassword, "this archive does not use key files")
 	}
 	return nil
 }
 
-func ReadHeader(r io.Reader) (uint32, error) {
-	var magic uint32
-	if err := binary.Read(r, binary.BigEndian, &magic); err != nil {
-		return 0, err
+// KeyFiles reports whether the archive needs key files to open.
+func (h *Header) KeyFiles() bool {
+	return h.Flags&FlagKeyFiles != 0
+}
+
+// Signed reports whether the archive carries an Ed25519 signature.
+func (h *Header) Signed() bool {
+	return h.Flags&FlagSigned != 0
+}
+
+// Padded reports whether entry data has to be stripped with Unpad.
+func (h *Header) Padded() bool {
+	return h.Flags&FlagPadded != 0
+}
+
+// EncryptedIndex reports whether the entry metadata is hidden in the archive.
+func (h *Header) EncryptedIndex() bool {
+	return h.Flags&FlagEncryptedIndex != 0
+}
+
+func WriteHeader(w io.Writer, h *Header) error {
+	_, err := w.Write(h.Bytes())
+	return err
+}
+
+func ReadHeader(r io.Reader) (*Header, error) {
+	buf := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, errorf(ErrNotArchive, "invalid archive format: file is too short")
+		}
+		return nil, err
 	}
-	if magic != MagicNumber {
-		return 0, errors.New("invalid archive format")
+	if binary.BigEndian.Uint32(buf[0:4]) != MagicNumber {
+		return nil, errorf(ErrNotArchive, "invalid archive format")
 	}
 
-	var version uint16
-	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
-		return 0, err
+	h := &Header{
+		Version: binary.BigEndian.Uint16(buf[4:6]),
+		Flags:   binary.BigEndian.Uint16(buf[6:8]),
+		KDF: KDFParams{
+			Algorithm: buf[8],
+			LogN:      buf[9],
+			R:         buf[10],
+			P:         buf[11],
+		},
+		Cipher: CipherSuite(buf[12]),
+	}
+	copy(h.ArchiveID[:], buf[13:29])
+	if h.Version != Version {
+		return nil, errorf(ErrUnsupportedVersion, "unsupported archive version: %d", h.Version)
+	}
+	if h.Flags&^knownFlags != 0 {
+		return nil, errorf(ErrUnsupportedVersion, "unsupported archive flags: %#04x", h.Flags)
+	}
+	if err := h.KDF.Validate(); err != nil {
+		return nil, err
+	}
+	if err := h.Cipher.Validate(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Entry describes one stored file. Entries live in the archive index; the
+// file data itself is a sequence of encrypted blobs right after the header.
+type Entry struct {
+	Name              string
+	CompressionMethod uint8
+	OriginalSize      uint64
+	StoredSize        uint64
+	// Checksum is the SHA-256 of the restored file. It is all zeros in
+	// archives with a plain index, where it would let anyone confirm a
+	// guess of the contents.
+	Checksum [digestSize]byte
+}
+
+// HasChecksum reports whether the entry records a checksum.
+func (e *Entry) HasChecksum() bool {
+	return e.Checksum != [digestSize]byte{}
+}
+
+// CompressionName returns the name of a compression method.
+func CompressionName(method uint8) string {
+	switch method {
+	case CompressionNone:
+		return "none"
+	case CompressionDeflate:
+		return "deflate"
+	}
+	return fmt.Sprintf("unknown (%d)", method)
+}
+
+// EntryAAD returns the associated data used to encrypt the entry at the given
+// position. It covers the header, the index and the entry metadata, so
+// renamed, reordered, dropped or duplicated entries fail authentication.
+func EntryAAD(h *Header, index uint32, e *Entry) []byte {
+	var buf bytes.Buffer
+	buf.Write(h.Bytes())
+	binary.Write(&buf, binary.BigEndian, index)
+	binary.Write(&buf, binary.BigEndian, uint16(len(e.Name)))
+	buf.WriteString(e.Name)
+	buf.WriteByte(e.CompressionMethod)
+	binary.Write(&buf, binary.BigEndian, e.OriginalSize)
+	return buf.Bytes()
+}
+
+func indexAAD(h *Header) []byte {
+	return append(h.Bytes(), "index"...)
+}
+
+func trailerAAD(h *Header, indexOffset uint64) []byte {
+	var buf bytes.Buffer
+	buf.Write(h.Bytes())
+	binary.Write(&buf, binary.BigEndian, uint32(TrailerMagic))
+	binary.Write(&buf, binary.BigEndian, indexOffset)
+	return buf.Bytes()
+}
+
+// ArchiveMeta records when and by which program an archive was written. It
+// is stored after the entries in the index, so it is as private as the file
+// names.
+type ArchiveMeta struct {
+	// Created is the zero time if it was not recorded.
+	Created time.Time
+	Creator string
+}
+
+// DefaultCreator is recorded as the creator of archives whose options don't
+// name one. Programs usually set it once at startup, including a version.
+var DefaultCreator = "seaf"
+
+func newArchiveMeta(opts ArchiveOptions) ArchiveMeta {
+	meta := ArchiveMeta{Created: time.Now().UTC().Truncate(time.Second), Creator: opts.Creator}
+	if meta.Creator == "" {
+		meta.Creator = DefaultCreator
+	}
+	if len(meta.Creator) > 255 {
+		meta.Creator = meta.Creator[:255]
+	}
+	return meta
+}
+
+func encodeIndex(entries []Entry, meta ArchiveMeta) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(len(entries)))
+	for i := range entries {
+		encodeEntry(&buf, &entries[i])
 	}
-	if version != Version {
-		return 0, fmt.Errorf("unsupported archive version: %d", version)
+	var created int64
+	if !meta.Created.IsZero() {
+		created = meta.Created.Unix()
 	}
+	binary.Write(&buf, binary.BigEndian, created)
+	buf.WriteByte(byte(len(meta.Creator)))
+	buf.WriteString(meta.Creator)
+	return buf.Bytes()
+}
+
+func encodeEntry(buf *bytes.Buffer, e *Entry) {
+	binary.Write(buf, binary.BigEndian, uint16(len(e.Name)))
+	buf.WriteString(e.Name)
+	buf.WriteByte(e.CompressionMethod)
+	binary.Write(buf, binary.BigEndian, e.OriginalSize)
+	binary.Write(buf, binary.BigEndian, e.StoredSize)
+	buf.Write(e.Checksum[:])
+}
+
+// encodedEntrySize is the length of an entry as written by encodeEntry.
+func encodedEntrySize(e *Entry) int {
+	return 2 + len(e.Name) + 1 + 8 + 8 + digestSize
+}
 
-	var totalFiles uint32
-	if err := binary.Read(r, binary.BigEndian, &totalFiles); err != nil {
+func decodeIndex(data []byte) ([]Entry, ArchiveMeta, error) {
+	r := bytes.NewReader(data)
+	errCorrupt := errorf(ErrCorrupt, "archive index is corrupted")
+
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, ArchiveMeta{}, errCorrupt
+	}
+
+	var entries []Entry
+	for i := uint32(0); i < count; i++ {
+		e, err := decodeEntry(r)
+		if err != nil {
+			return nil, ArchiveMeta{}, errCorrupt
+		}
+		entries = append(entries, e)
+	}
+
+	var meta ArchiveMeta
+	var created int64
+	if err := binary.Read(r, binary.BigEndian, &created); err != nil {
+		return nil, ArchiveMeta{}, errCorrupt
+	}
+	if created != 0 {
+		meta.Created = time.Unix(created, 0).UTC()
+	}
+	creatorLen, err := r.ReadByte()
+	if err != nil {
+		return nil, ArchiveMeta{}, errCorrupt
+	}
+	creator := make([]byte, creatorLen)
+	if _, err := io.ReadFull(r, creator); err != nil {
+		return nil, ArchiveMeta{}, errCorrupt
+	}
+	meta.Creator = string(creator)
+	if r.Len() != 0 {
+		return nil, ArchiveMeta{}, errCorrupt
+	}
+
+	return entries, meta, nil
+}
+
+func decodeEntry(r io.Reader) (Entry, error) {
+	var nameLen uint16
+	if err := binary.Read(r, binary.BigEndian, &nameLen); err != nil {
+		return Entry{}, err
+	}
+
+	nameBytes := make([]byte, nameLen)
+	if _, err := io.ReadFull(r, nameBytes); err != nil {
+		return Entry{}, err
+	}
+
+	e := Entry{Name: string(nameBytes)}
+	if err := binary.Read(r, binary.BigEndian, &e.CompressionMethod); err != nil {
+		return Entry{}, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &e.OriginalSize); err != nil {
+		return Entry{}, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &e.StoredSize); err != nil {
+		return Entry{}, err
+	}
+	if _, err := io.ReadFull(r, e.Checksum[:]); err != nil {
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+// Each entry's data is preceded by a sync prefix, so salvage can find
+// entries without the index:
+//
+//	sync marker | masked local header length u32 | sealed local header
+//
+// The marker and the mask are keyed by the archive's sync key and the entry
+// position, so the prefix looks random without the password. The local
+// header repeats the entry's index record under the entry key.
+const syncMarkerSize = 16
+
+func localHeaderAAD(h *Header, index uint32) []byte {
+	buf := append(h.Bytes(), "local"...)
+	return binary.BigEndian.AppendUint32(buf, index)
+}
+
+// sealSyncPrefix returns the sync prefix for a finished entry.
+func sealSyncPrefix(keys *ArchiveKeys, h *Header, index uint32, e *Entry, entryKey []byte) ([]byte, error) {
+	var local bytes.Buffer
+	encodeEntry(&local, e)
+	sealed, err := Encrypt(h.Cipher, local.Bytes(), entryKey, localHeaderAAD(h, index))
+	if err != nil {
+		return nil, err
+	}
+
+	marker, mask := keys.syncMarker(index)
+	prefix := make([]byte, 0, syncMarkerSize+4+len(sealed))
+	prefix = append(prefix, marker[:]...)
+	prefix = binary.BigEndian.AppendUint32(prefix, uint32(len(sealed))^mask)
+	return append(prefix, sealed...), nil
+}
+
+// syncPrefixSize returns the length of the sync prefix written before e.
+func syncPrefixSize(h *Header, e *Entry) (uint64, error) {
+	overhead, err := h.Cipher.overhead()
+	if err != nil {
 		return 0, err
 	}
+	return uint64(syncMarkerSize + 4 + overhead + encodedEntrySize(e)), nil
+}
 
-	return totalFiles, nil
+// sealIndex encodes the index and encrypts it when the header asks for it.
+func sealIndex(keys *ArchiveKeys, h *Header, entries []Entry, meta ArchiveMeta) ([]byte, error) {
+	index := encodeIndex(entries, meta)
+	if !h.EncryptedIndex() {
+		return index, nil
+	}
+	return Encrypt(h.Cipher, index, keys.Index, indexAAD(h))
 }
 
-func WriteFileEntry(w io.Writer, filename string, compressionMethod uint8, encryptedData []byte) error {
-	nameLen := uint16(len(filename))
-	if err := binary.Write(w, binary.BigEndian, nameLen); err != nil {
-		return err
+// WriteIndex writes the index section. It returns the bytes written so the
+// caller can digest them.
+func WriteIndex(w io.Writer, keys *ArchiveKeys, h *Header, entries []Entry, meta ArchiveMeta) ([]byte, error) {
+	index, err := sealIndex(keys, h, entries, meta)
+	if err != nil {
+		return nil, err
 	}
 
-	if _, err := w.Write([]byte(filename)); err != nil {
-		return err
+	section := make([]byte, 4, 4+len(index))
+	binary.BigEndian.PutUint32(section, uint32(len(index)))
+	section = append(section, index...)
+
+	if _, err := w.Write(section); err != nil {
+		return nil, err
 	}
+	return section, nil
+}
+
+// sealTrailer encrypts the entry count and the digests of the data and index
+// sections. It authenticates the archive as a whole.
+func sealTrailer(keys *ArchiveKeys, h *Header, indexOffset uint64, count uint32, dataDigest, indexDigest []byte) ([]byte, error) {
+	plaintext := make([]byte, 4, 4+len(dataDigest)+len(indexDigest))
+	binary.BigEndian.PutUint32(plaintext, count)
+	plaintext = append(plaintext, dataDigest...)
+	plaintext = append(plaintext, indexDigest...)
+
+	return Encrypt(h.Cipher, plaintext, keys.MAC, trailerAAD(h, indexOffset))
+}
 
-	if err := binary.Write(w, binary.BigEndian, compressionMethod); err != nil {
+func WriteTrailer(w io.Writer, keys *ArchiveKeys, h *Header, indexOffset uint64, count uint32, dataDigest, indexDigest []byte) error {
+	sealed, err := sealTrailer(keys, h, indexOffset, count, dataDigest, indexDigest)
+	if err != nil {
 		return err
 	}
 
-	encryptedDataLen := uint32(len(encryptedData))
-	if err := binary.Write(w, binary.BigEndian, encryptedDataLen); err != nil {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(sealed))); err != nil {
 		return err
 	}
+	_, err = w.Write(sealed)
+	return err
+}
 
-	if _, err := w.Write(encryptedData); err != nil {
+// WriteFooter ends the archive with the plaintext pointer to the index.
+func WriteFooter(w io.Writer, indexOffset uint64) error {
+	if err := binary.Write(w, binary.BigEndian, indexOffset); err != nil {
 		return err
 	}
+	return binary.Write(w, binary.BigEndian, uint32(TrailerMagic))
+}
+
+// Index is the authenticated table of contents of an opened archive. Header
+// is the one the entries are bound to; for a hidden archive it is not the
+// header stored at the start of the file.
+type Index struct {
+	Header      *Header
+	Entries     []Entry
+	Meta        ArchiveMeta
+	DataOffset  uint64
+	IndexOffset uint64
+	dataDigest  []byte
+}
+
+// errTrailerAuth means the trailer did not open with the given key, which
+// is what a wrong password looks like.
+var errTrailerAuth = errorf(ErrWrongPassword, "archive is corrupted or the password is wrong: trailer failed authentication")
+
+// trailerEnd returns where the trailer of the outer archive ends, which is
+// where the fixed-size sections before the footer begin.
+func trailerEnd(h *Header, size int64) (uint64, error) {
+	end := size - hiddenSlotSize - footerSize
+	if h.Signed() {
+		end -= signatureSize
+	}
+	if end < headerSize {
+		return 0, errorf(ErrCorrupt, "archive is truncated: trailer is missing")
+	}
+	return uint64(end), nil
+}
+
+// ReadIndex locates the index through the footer, checks it against the
+// trailer and decodes it. The data section is verified separately with
+// VerifyData once all blobs have been read.
+func ReadIndex(r io.ReadSeeker, keys *ArchiveKeys, h *Header) (*Index, error) {
+	indexOffset, section, sealedTrailer, err := locateIndex(r, h)
+	if err != nil {
+		return nil, err
+	}
+	return openIndex(keys, h, headerSize, indexOffset, section, section[4:], sealedTrailer[4:])
+}
+
+// readPlainIndex decodes the index of an archive with a plain index without
+// the password. Nothing is authenticated.
+func readPlainIndex(r io.ReadSeeker, h *Header) ([]Entry, ArchiveMeta, error) {
+	if h.EncryptedIndex() {
+		return nil, ArchiveMeta{}, errors.New("the index is encrypted")
+	}
+	_, section, _, err := locateIndex(r, h)
+	if err != nil {
+		return nil, ArchiveMeta{}, err
+	}
+	return decodeIndex(section[4:])
+}
+
+// locateIndex finds the index and trailer sections through the footer. Both
+// are returned with their length prefixes.
+func locateIndex(r io.ReadSeeker, h *Header) (indexOffset uint64, section, sealedTrailer []byte, err error) {
+	size, err := archiveSize(r)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return locateIndexAt(r, h, size)
+}
 
+// locateIndexAt is locateIndex for an archive of the given size.
+func locateIndexAt(r io.ReadSeeker, h *Header, size int64) (indexOffset uint64, section, sealedTrailer []byte, err error) {
+	end, err := trailerEnd(h, size)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	if _, err := r.Seek(size-footerSize, io.SeekStart); err != nil {
+		return 0, nil, nil, err
+	}
+	var magic uint32
+	if err := binary.Read(r, binary.BigEndian, &indexOffset); err != nil {
+		return 0, nil, nil, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &magic); err != nil {
+		return 0, nil, nil, err
+	}
+	if magic != TrailerMagic {
+		return 0, nil, nil, errorf(ErrCorrupt, "archive is truncated: trailer is missing")
+	}
+	if indexOffset < headerSize || indexOffset > end {
+		return 0, nil, nil, errorf(ErrCorrupt, "archive is corrupted: invalid index offset")
+	}
+
+	if _, err := r.Seek(int64(indexOffset), io.SeekStart); err != nil {
+		return 0, nil, nil, err
+	}
+	section, err = readSection(r, end-indexOffset)
+	if err != nil {
+		return 0, nil, nil, errorf(ErrCorrupt, "archive is corrupted: index is incomplete")
+	}
+	sealedTrailer, err = readSection(r, end-indexOffset-uint64(len(section)))
+	if err != nil {
+		return 0, nil, nil, errorf(ErrCorrupt, "archive is corrupted: trailer is incomplete")
+	}
+	if pos, _ := r.Seek(0, io.SeekCurrent); uint64(pos) != end {
+		return 0, nil, nil, errorf(ErrCorrupt, "archive is corrupted: unexpected data after trailer")
+	}
+	return indexOffset, section, sealedTrailer, nil
+}
+
+// openIndex authenticates an index against its trailer and decodes it.
+// indexSection is what the trailer digest covers and index is the sealed or
+// plain index inside it.
+func openIndex(keys *ArchiveKeys, h *Header, dataOffset, indexOffset uint64, indexSection, index, sealedTrailer []byte) (*Index, error) {
+	trailer, err := Decrypt(h.Cipher, sealedTrailer, keys.MAC, trailerAAD(h, indexOffset))
+	if err != nil {
+		return nil, errTrailerAuth
+	}
+	if len(trailer) != 4+2*digestSize {
+		return nil, errorf(ErrCorrupt, "archive is corrupted: invalid trailer")
+	}
+	if subtle.ConstantTimeCompare(trailer[4+digestSize:], sumDigest(indexSection)) != 1 {
+		return nil, errorf(ErrCorrupt, "archive is corrupted: index does not match the trailer")
+	}
+
+	if h.EncryptedIndex() {
+		index, err = Decrypt(h.Cipher, index, keys.Index, indexAAD(h))
+		if err != nil {
+			return nil, errorf(ErrCorrupt, "archive is corrupted: index failed authentication")
+		}
+	}
+	entries, meta, err := decodeIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(entries)) != binary.BigEndian.Uint32(trailer) {
+		return nil, errorf(ErrCorrupt, "archive is corrupted: entry count mismatch")
+	}
+
+	// The data may be followed by free space, but never overlap the index.
+	dataEnd := dataOffset
+	for i := range entries {
+		prefixSize, err := syncPrefixSize(h, &entries[i])
+		if err != nil {
+			return nil, err
+		}
+		dataEnd += prefixSize + entries[i].StoredSize
+	}
+	if dataEnd > indexOffset {
+		return nil, errorf(ErrCorrupt, "archive is corrupted: entry sizes do not match the index offset")
+	}
+
+	return &Index{
+		Header:      h,
+		Entries:     entries,
+		Meta:        meta,
+		DataOffset:  dataOffset,
+		IndexOffset: indexOffset,
+		dataDigest:  trailer[4 : 4+digestSize],
+	}, nil
+}
+
+// VerifyData checks the digest of the data section read by the caller.
+func (idx *Index) VerifyData(digest []byte) error {
+	if subtle.ConstantTimeCompare(idx.dataDigest, digest) != 1 {
+		return errorf(ErrCorrupt, "archive is corrupted: entries do not match the trailer")
+	}
 	return nil
 }
+
+func sumDigest(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// readSection reads a length-prefixed section that may not be longer than
+// limit bytes, including its prefix. The prefix is kept in the result.
+func readSection(r io.Reader, limit uint64) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if uint64(length)+4 > limit {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	section := make([]byte, 4+length)
+	binary.BigEndian.PutUint32(section, length)
+	if _, err := io.ReadFull(r, section[4:]); err != nil {
+		return nil, err
+	}
+	return section, nil
+}
@@ -0,0 +1,220 @@
+package archiver
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
+
+// Every archive ends with a fixed-size hidden slot right before the signature
+// and footer. Without a hidden archive it holds random bytes; with one, it is
+// the sealed location of that archive inside the outer archive's free space.
+// A hidden archive has no plaintext framing at all, so the slot and the free
+// space look the same either way.
+const hiddenSlotSize = 96
+
+// HiddenArchive is a second archive stored inside the free space of the
+// outer one and opened with its own credentials.
+type HiddenArchive struct {
+	Credentials Credentials
+	Files       []FileInfo
+}
+
+type hiddenSlot struct {
+	archiveID     [16]byte
+	flags         uint16
+	dataOffset    uint64
+	indexOffset   uint64
+	trailerOffset uint64
+	end           uint64
+}
+
+// header returns the header the hidden entries are bound to. It is never
+// written to the file.
+func (s *hiddenSlot) header(outer *Header) *Header {
+	return &Header{
+		Version:   outer.Version,
+		Flags:     s.flags,
+		KDF:       outer.KDF,
+		Cipher:    outer.Cipher,
+		ArchiveID: s.archiveID,
+	}
+}
+
+func randomHiddenSlot() ([]byte, error) {
+	slot := make([]byte, hiddenSlotSize)
+	if _, err := rand.Read(slot); err != nil {
+		return nil, err
+	}
+	return slot, nil
+}
+
+func sealHiddenSlot(outer *Header, keys *ArchiveKeys, s *hiddenSlot) ([]byte, error) {
+	key, err := keys.hiddenSlotKey()
+	if err != nil {
+		return nil, err
+	}
+
+	overhead, err := outer.Cipher.overhead()
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, hiddenSlotSize-overhead)
+	copy(plaintext[0:16], s.archiveID[:])
+	binary.BigEndian.PutUint16(plaintext[16:18], s.flags)
+	binary.BigEndian.PutUint64(plaintext[18:26], s.dataOffset)
+	binary.BigEndian.PutUint64(plaintext[26:34], s.indexOffset)
+	binary.BigEndian.PutUint64(plaintext[34:42], s.trailerOffset)
+	binary.BigEndian.PutUint64(plaintext[42:50], s.end)
+
+	return Encrypt(outer.Cipher, plaintext, key, outer.Bytes())
+}
+
+func openHiddenSlot(outer *Header, keys *ArchiveKeys, sealed []byte) (*hiddenSlot, error) {
+	key, err := keys.hiddenSlotKey()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := Decrypt(outer.Cipher, sealed, key, outer.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if len(plaintext) < 50 {
+		return nil, errorf(ErrCorrupt, "archive is corrupted: invalid hidden slot")
+	}
+
+	s := &hiddenSlot{
+		flags:         binary.BigEndian.Uint16(plaintext[16:18]),
+		dataOffset:    binary.BigEndian.Uint64(plaintext[18:26]),
+		indexOffset:   binary.BigEndian.Uint64(plaintext[26:34]),
+		trailerOffset: binary.BigEndian.Uint64(plaintext[34:42]),
+		end:           binary.BigEndian.Uint64(plaintext[42:50]),
+	}
+	copy(s.archiveID[:], plaintext[0:16])
+	return s, nil
+}
+
+// writeHiddenArchive writes the hidden entries, index and trailer starting at
+// dataOffset, with no length prefixes or other plaintext. It returns the
+// sealed slot and the number of bytes written.
+func writeHiddenArchive(w io.Writer, outer *Header, masterKey *Key, dataOffset uint64, hidden *HiddenArchive, meta ArchiveMeta, opts ArchiveOptions) ([]byte, uint64, error) {
+	s := &hiddenSlot{dataOffset: dataOffset, flags: FlagEncryptedIndex}
+	if len(hidden.Credentials.KeyFiles) > 0 {
+		s.flags |= FlagKeyFiles
+	}
+	s.flags |= outer.Flags & FlagPadded
+	if _, err := rand.Read(s.archiveID[:]); err != nil {
+		return nil, 0, err
+	}
+	header := s.header(outer)
+
+	slotKeys, err := DeriveArchiveKeys(masterKey, outer)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer slotKeys.Wipe()
+	keys, err := DeriveArchiveKeys(masterKey, header)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer keys.Wipe()
+
+	stats, dataDigest, dataSize, err := writeEntries(w, header, keys, hidden.Files, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries := statsEntries(stats)
+	s.indexOffset = dataOffset + dataSize
+
+	index, err := sealIndex(keys, header, entries, meta)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := w.Write(index); err != nil {
+		return nil, 0, err
+	}
+	s.trailerOffset = s.indexOffset + uint64(len(index))
+
+	trailer, err := sealTrailer(keys, header, s.indexOffset, uint32(len(entries)), dataDigest, sumDigest(index))
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := w.Write(trailer); err != nil {
+		return nil, 0, err
+	}
+	s.end = s.trailerOffset + uint64(len(trailer))
+
+	sealed, err := sealHiddenSlot(outer, slotKeys, s)
+	if err != nil {
+		return nil, 0, err
+	}
+	return sealed, s.end - dataOffset, nil
+}
+
+// readHiddenSlot tries the hidden slot with the given master key and returns
+// its contents if it opens.
+func readHiddenSlot(r io.ReadSeeker, masterKey *Key, outer *Header) (*hiddenSlot, error) {
+	size, err := archiveSize(r)
+	if err != nil {
+		return nil, err
+	}
+	end, err := trailerEnd(outer, size)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, hiddenSlotSize)
+	if _, err := r.Seek(int64(end), io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return nil, err
+	}
+
+	slotKeys, err := DeriveArchiveKeys(masterKey, outer)
+	if err != nil {
+		return nil, err
+	}
+	s, err := openHiddenSlot(outer, slotKeys, sealed)
+	slotKeys.Wipe()
+	if err != nil {
+		return nil, errTrailerAuth
+	}
+	if s.dataOffset < headerSize || s.indexOffset < s.dataOffset || s.trailerOffset < s.indexOffset ||
+		s.end < s.trailerOffset || s.end > end {
+		return nil, errorf(ErrCorrupt, "archive is corrupted: invalid hidden slot")
+	}
+	return s, nil
+}
+
+// readHiddenIndex tries the hidden slot with the given master key and, if it
+// opens, returns the authenticated index of the hidden archive.
+func readHiddenIndex(r io.ReadSeeker, masterKey *Key, outer *Header) (*Index, *ArchiveKeys, error) {
+	s, err := readHiddenSlot(r, masterKey, outer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tail := make([]byte, s.end-s.indexOffset)
+	if _, err := r.Seek(int64(s.indexOffset), io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.ReadFull(r, tail); err != nil {
+		return nil, nil, err
+	}
+	index := tail[:s.trailerOffset-s.indexOffset]
+	trailer := tail[s.trailerOffset-s.indexOffset:]
+
+	header := s.header(outer)
+	keys, err := DeriveArchiveKeys(masterKey, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	idx, err := openIndex(keys, header, s.dataOffset, s.indexOffset, index, index, trailer)
+	if err != nil {
+		keys.Wipe()
+		return nil, nil, err
+	}
+	return idx, keys, nil
+}
@@ -0,0 +1,93 @@
+package archiver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHiddenArchive(t *testing.T) {
+	decoy := randomBytes(t, 5000)
+	secret := randomBytes(t, 3000)
+	hiddenCreds := Credentials{Password: []byte("HiddenPass-rocket-7-lemon")}
+
+	dir := t.TempDir()
+	decoyPath := filepath.Join(dir, "decoy.txt")
+	secretPath := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(decoyPath, decoy, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(secretPath, secret, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	hiddenFiles, err := CollectFiles([]string{secretPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ArchiveOptions{Reserve: 64 * 1024}
+	plain := archiveTestFiles(t, t.TempDir(), []string{decoyPath}, opts)
+	opts.Hidden = &HiddenArchive{Credentials: hiddenCreds, Files: hiddenFiles}
+	archive := archiveTestFiles(t, dir, []string{decoyPath}, opts)
+
+	plainInfo, err := os.Stat(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != plainInfo.Size() {
+		t.Errorf("archive with a hidden archive is %d bytes, without %d", info.Size(), plainInfo.Size())
+	}
+
+	tests := []struct {
+		name  string
+		creds Credentials
+		entry string
+		data  []byte
+	}{
+		{"outer", testCredentials(), "decoy.txt", decoy},
+		{"hidden", hiddenCreds, "secret.txt", secret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, err := ListArchive(tt.creds, testSalt, archive)
+			if err != nil {
+				t.Fatalf("ListArchive: %v", err)
+			}
+			if len(index.Entries) != 1 || index.Entries[0].Name != tt.entry {
+				t.Fatalf("ListArchive = %+v, want only %s", index.Entries, tt.entry)
+			}
+
+			report, err := TestArchive(tt.creds, testSalt, archive)
+			if err != nil {
+				t.Fatalf("TestArchive: %v", err)
+			}
+			if !report.OK() || len(report.Entries) != 1 {
+				t.Fatalf("TestArchive = %+v, want one good entry", report)
+			}
+
+			outputDir := t.TempDir()
+			if _, err := ExtractArchive(tt.creds, testSalt, archive, outputDir); err != nil {
+				t.Fatalf("ExtractArchive: %v", err)
+			}
+			extracted, err := os.ReadDir(outputDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(extracted) != 1 || extracted[0].Name() != tt.entry {
+				t.Fatalf("extracted %v, want only %s", extracted, tt.entry)
+			}
+			got, err := os.ReadFile(filepath.Join(outputDir, tt.entry))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("%s differs from the original", tt.entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package archiver
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// ArchiveInfo is what anyone can learn about an archive without the password.
+type ArchiveInfo struct {
+	Header *Header
+	// Size is the size of the archive proper, across all volumes and
+	// without the recovery record.
+	Size int64
+	// Volumes is the number of volumes of a split archive, or 0.
+	Volumes int
+	// RecoverySize is the size of the recovery record, or 0 if there is none.
+	RecoverySize int64
+	// Contents summarizes a plain index, read without authentication. It is
+	// nil if the index is encrypted; ListArchive and SummarizeIndex give it
+	// with the password.
+	Contents *ContentsInfo
+}
+
+// ContentsInfo summarizes the index of an archive.
+type ContentsInfo struct {
+	Entries      int
+	OriginalSize uint64
+	StoredSize   uint64
+	// Compression counts the entries stored with each compression method,
+	// by CompressionName.
+	Compression map[string]int
+	Meta        ArchiveMeta
+}
+
+// SummarizeIndex returns the totals of an opened index.
+func SummarizeIndex(idx *Index) *ContentsInfo {
+	c := &ContentsInfo{Entries: len(idx.Entries), Compression: map[string]int{}, Meta: idx.Meta}
+	for _, e := range idx.Entries {
+		c.OriginalSize += e.OriginalSize
+		c.StoredSize += e.StoredSize
+		c.Compression[CompressionName(e.CompressionMethod)]++
+	}
+	return c
+}
+
+// ReadArchiveInfo reads the public parts of an archive.
+func ReadArchiveInfo(archiveFile string) (*ArchiveInfo, error) {
+	f, err := openArchiveFile(archiveFile, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info := &ArchiveInfo{}
+	if v, ok := f.(*volumeSet); ok {
+		info.Volumes = len(v.files)
+	}
+	if info.Header, err = ReadHeader(f); err != nil {
+		return nil, err
+	}
+
+	total, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	l, err := readRecoveryLayout(f)
+	switch {
+	case err == nil:
+		info.Size = int64(l.protected)
+		info.RecoverySize = total - info.Size
+	case errors.Is(err, errNoRecoveryRecord):
+		info.Size = total
+	default:
+		if info.Size, err = archiveSize(f); err != nil {
+			return nil, err
+		}
+		info.RecoverySize = total - info.Size
+	}
+
+	if !info.Header.EncryptedIndex() {
+		entries, meta, err := readPlainIndex(f, info.Header)
+		if err != nil {
+			return nil, err
+		}
+		info.Contents = SummarizeIndex(&Index{Header: info.Header, Entries: entries, Meta: meta})
+	}
+	return info, nil
+}
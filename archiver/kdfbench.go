@@ -0,0 +1,83 @@
+package archiver
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// DefaultKDFMaxMemory caps the memory CalibrateKDF will pick, so an archive
+// made on a large machine still opens on a small one. It is also the most
+// memory an archive header may ask for.
+const DefaultKDFMaxMemory = 1 << 30
+
+func (p KDFParams) String() string {
+	return fmt.Sprintf("scrypt N=2^%d r=%d p=%d", p.LogN, p.R, p.P)
+}
+
+// Memory returns the bytes scrypt needs with these parameters.
+func (p KDFParams) Memory() uint64 {
+	return 128*uint64(p.R)<<p.LogN + 128*uint64(p.R)*uint64(p.P)
+}
+
+// BenchmarkKDF times one key derivation with the given parameters.
+func BenchmarkKDF(params KDFParams) (time.Duration, error) {
+	if err := params.Validate(); err != nil {
+		return 0, err
+	}
+	var secret, salt [16]byte
+	if _, err := rand.Read(secret[:]); err != nil {
+		return 0, err
+	}
+	if _, err := rand.Read(salt[:]); err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	if _, err := scrypt.Key(secret[:], salt[:], 1<<params.LogN, int(params.R), int(params.P), keySize); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// CalibrateKDF returns the most expensive scrypt parameters that derive a key
+// within target on this machine and need at most maxMemory bytes. Only N is
+// varied; r and p keep their defaults.
+func CalibrateKDF(target time.Duration, maxMemory uint64) (KDFParams, error) {
+	maxMemory = min(maxMemory, DefaultKDFMaxMemory)
+	params := KDFParams{Algorithm: KDFScrypt, LogN: 14, R: DefaultKDFParams.R, P: DefaultKDFParams.P}
+	elapsed, err := BenchmarkKDF(params)
+	if err != nil {
+		return KDFParams{}, err
+	}
+
+	// The time doubles with each step of LogN, so extrapolate from one
+	// cheap run and then confirm the pick.
+	for elapsed > target && params.LogN > 10 {
+		params.LogN--
+		elapsed /= 2
+	}
+	for params.LogN < 30 {
+		next := params
+		next.LogN++
+		if elapsed*2 > target || next.Memory() > maxMemory {
+			break
+		}
+		params = next
+		elapsed *= 2
+	}
+
+	for params.LogN > 10 {
+		elapsed, err := BenchmarkKDF(params)
+		if err != nil {
+			return KDFParams{}, err
+		}
+		if elapsed <= target {
+			break
+		}
+		params.LogN--
+	}
+	return params, nil
+}
@@ -0,0 +1,88 @@
+package archiver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Credentials unlock an archive: a password, one or more key files, or both.
+// Any file can serve as a key file; only its contents matter. The password is
+// kept as bytes so it can be wiped once the archive operation is done.
+type Credentials struct {
+	Password []byte
+	KeyFiles []string
+}
+
+func (c Credentials) Validate() error {
+	if len(c.Password) == 0 && len(c.KeyFiles) == 0 {
+		return errors.New("a password or a key file is required")
+	}
+	return nil
+}
+
+// Wipe overwrites the password in place.
+func (c *Credentials) Wipe() {
+	wipe(c.Password)
+	c.Password = nil
+}
+
+// MinKeyFileSize is the smallest key file accepted. Key files skip the
+// password strength check, so they must carry real key material.
+const MinKeyFileSize = 32
+
+// KeyFileDigest hashes the contents of a key file.
+func KeyFileDigest(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key file %s: %w", path, err)
+	}
+	if n < MinKeyFileSize {
+		return nil, fmt.Errorf("key file %s is too short: %d bytes, at least %d are needed", path, n, MinKeyFileSize)
+	}
+	return h.Sum(nil), nil
+}
+
+// secret returns the input for the key derivation function. Without key files
+// it is a copy of the password; otherwise the password hash and the key file
+// digests are combined, so the order of key files does not matter. The caller
+// wipes it after use.
+func (c Credentials) secret() ([]byte, error) {
+	if len(c.KeyFiles) == 0 {
+		return append([]byte(nil), c.Password...), nil
+	}
+
+	digests := make([][]byte, 0, len(c.KeyFiles))
+	for _, path := range c.KeyFiles {
+		d, err := KeyFileDigest(path)
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, d)
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		return bytes.Compare(digests[i], digests[j]) < 0
+	})
+
+	passwordHash := sha256.Sum256(c.Password)
+	h := sha256.New()
+	h.Write([]byte("seaf keyfiles"))
+	h.Write(passwordHash[:])
+	wipe(passwordHash[:])
+	for _, d := range digests {
+		h.Write(d)
+		wipe(d)
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,148 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+type PaddingMode uint8
+
+const (
+	PaddingNone PaddingMode = iota
+	// PaddingPow2 rounds every entry up to the next power of two.
+	PaddingPow2
+	// PaddingPadme rounds to a value with few significant bits, which leaks
+	// O(log log n) bits of the size at no more than 12% overhead.
+	PaddingPadme
+	// PaddingFixed rounds up to a multiple of a fixed block size.
+	PaddingFixed
+)
+
+// MaxPaddingBlock is the largest block size of fixed padding. Every padded
+// entry is held in memory, so a larger block would only exhaust it.
+const MaxPaddingBlock = 1 << 30
+
+// PaddingPolicy decides how far entry data is padded before encryption, so
+// stored sizes don't reveal the exact size of known files.
+type PaddingPolicy struct {
+	Mode  PaddingMode
+	Block uint64
+}
+
+// ParsePaddingPolicy accepts none, pow2, padme or fixed:N.
+func ParsePaddingPolicy(s string) (PaddingPolicy, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); {
+	case s == "" || s == "none":
+		return PaddingPolicy{Mode: PaddingNone}, nil
+	case s == "pow2":
+		return PaddingPolicy{Mode: PaddingPow2}, nil
+	case s == "padme":
+		return PaddingPolicy{Mode: PaddingPadme}, nil
+	case strings.HasPrefix(s, "fixed:"):
+		block, err := strconv.ParseUint(strings.TrimPrefix(s, "fixed:"), 10, 64)
+		if err != nil || block == 0 {
+			return PaddingPolicy{}, fmt.Errorf("invalid fixed padding block %q", s)
+		}
+		if block > MaxPaddingBlock {
+			return PaddingPolicy{}, fmt.Errorf("fixed padding block %d is larger than the maximum of %d", block, MaxPaddingBlock)
+		}
+		return PaddingPolicy{Mode: PaddingFixed, Block: block}, nil
+	default:
+		return PaddingPolicy{}, fmt.Errorf("unknown padding policy %q (use none, pow2, padme or fixed:N)", s)
+	}
+}
+
+func (p PaddingPolicy) String() string {
+	switch p.Mode {
+	case PaddingNone:
+		return "none"
+	case PaddingPow2:
+		return "pow2"
+	case PaddingPadme:
+		return "padme"
+	case PaddingFixed:
+		return fmt.Sprintf("fixed:%d", p.Block)
+	default:
+		return fmt.Sprintf("unknown(%d)", p.Mode)
+	}
+}
+
+// PaddedSize returns the size n bytes of entry data take up once padded. Any
+// padding needs at least one byte for the end marker. Sizes that can't be
+// rounded up without overflowing return math.MaxUint64, which no entry can
+// have.
+func (p PaddingPolicy) PaddedSize(n uint64) uint64 {
+	if p.Mode == PaddingNone {
+		return n
+	}
+	if n == math.MaxUint64 {
+		return n
+	}
+
+	n++
+	switch p.Mode {
+	case PaddingPow2:
+		if n&(n-1) == 0 {
+			return n
+		}
+		if bits.Len64(n) == 64 {
+			return math.MaxUint64
+		}
+		return 1 << bits.Len64(n)
+	case PaddingPadme:
+		if n < 2 {
+			return n
+		}
+		e := uint64(bits.Len64(n) - 1)
+		s := uint64(bits.Len64(e))
+		mask := uint64(1)<<(e-s) - 1
+		if n > math.MaxUint64-mask {
+			return math.MaxUint64
+		}
+		return (n + mask) &^ mask
+	case PaddingFixed:
+		if p.Block == 0 {
+			return n
+		}
+		r := n % p.Block
+		if r == 0 {
+			return n
+		}
+		if n > math.MaxUint64-(p.Block-r) {
+			return math.MaxUint64
+		}
+		return n + p.Block - r
+	default:
+		return n
+	}
+}
+
+// Pad appends an 0x80 marker and zeros up to the size chosen by the policy.
+func Pad(data []byte, p PaddingPolicy) []byte {
+	if p.Mode == PaddingNone {
+		return data
+	}
+
+	padded := make([]byte, p.PaddedSize(uint64(len(data))))
+	copy(padded, data)
+	padded[len(data)] = 0x80
+	return padded
+}
+
+// Unpad strips padding added by Pad.
+func Unpad(data []byte) ([]byte, error) {
+	for i := len(data) - 1; i >= 0; i-- {
+		switch data[i] {
+		case 0x00:
+			continue
+		case 0x80:
+			return data[:i], nil
+		}
+		break
+	}
+	return nil, errors.New("invalid padding")
+}
@@ -0,0 +1,98 @@
+package archiver
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestPaddedSize(t *testing.T) {
+	fixed16 := PaddingPolicy{Mode: PaddingFixed, Block: 16}
+	pow2 := PaddingPolicy{Mode: PaddingPow2}
+	padme := PaddingPolicy{Mode: PaddingPadme}
+	tests := []struct {
+		policy PaddingPolicy
+		n      uint64
+		want   uint64
+	}{
+		{PaddingPolicy{}, 15, 15},
+		// The end marker needs a byte, so a full block spills into the next.
+		{fixed16, 0, 16},
+		{fixed16, 14, 16},
+		{fixed16, 15, 16},
+		{fixed16, 16, 32},
+		{fixed16, 31, 32},
+		{fixed16, 32, 48},
+		{pow2, 0, 1},
+		{pow2, 1, 2},
+		{pow2, 3, 4},
+		{pow2, 4, 8},
+		{pow2, 1023, 1024},
+		{pow2, 1024, 2048},
+		{padme, 0, 1},
+		{padme, 99, 104},
+		{padme, 103, 104},
+		{padme, 104, 112},
+		// Rounding up would overflow.
+		{PaddingPolicy{Mode: PaddingFixed, Block: MaxPaddingBlock}, math.MaxUint64 - 1, math.MaxUint64},
+		{pow2, 1 << 63, math.MaxUint64},
+		{padme, math.MaxUint64 - 1, math.MaxUint64},
+		{fixed16, math.MaxUint64, math.MaxUint64},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.PaddedSize(tt.n); got != tt.want {
+			t.Errorf("%s.PaddedSize(%d) = %d, want %d", tt.policy, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestPadUnpad(t *testing.T) {
+	for _, policy := range []PaddingPolicy{
+		{Mode: PaddingFixed, Block: 16},
+		{Mode: PaddingPow2},
+		{Mode: PaddingPadme},
+	} {
+		for _, n := range []int{0, 1, 14, 15, 16, 17, 31, 32, 33, 1000} {
+			// Data ending in marker and zero bytes must survive.
+			data := bytes.Repeat([]byte{0x80, 0x00}, n)[:n]
+			padded := Pad(data, policy)
+			if uint64(len(padded)) != policy.PaddedSize(uint64(n)) {
+				t.Errorf("%s: Pad(%d bytes) has %d bytes, want %d", policy, n, len(padded), policy.PaddedSize(uint64(n)))
+			}
+			got, err := Unpad(padded)
+			if err != nil {
+				t.Fatalf("%s: Unpad(Pad(%d bytes)): %v", policy, n, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("%s: Unpad(Pad(%d bytes)) differs from the data", policy, n)
+			}
+		}
+	}
+
+	if _, err := Unpad(make([]byte, 16)); err == nil {
+		t.Error("Unpad accepted data without an end marker")
+	}
+}
+
+func TestParsePaddingPolicy(t *testing.T) {
+	valid := map[string]PaddingPolicy{
+		"":                 {Mode: PaddingNone},
+		"none":             {Mode: PaddingNone},
+		"POW2":             {Mode: PaddingPow2},
+		"padme":            {Mode: PaddingPadme},
+		"fixed:4096":       {Mode: PaddingFixed, Block: 4096},
+		"fixed:1073741824": {Mode: PaddingFixed, Block: MaxPaddingBlock},
+	}
+	for s, want := range valid {
+		got, err := ParsePaddingPolicy(s)
+		if err != nil || got != want {
+			t.Errorf("ParsePaddingPolicy(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+
+	for _, s := range []string{"fixed:0", "fixed:-1", "fixed:1073741825", "fixed:18446744073709551615", "fixed:", "random"} {
+		if _, err := ParsePaddingPolicy(s); err == nil {
+			t.Errorf("ParsePaddingPolicy(%q) succeeded", s)
+		}
+	}
+}
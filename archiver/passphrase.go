@@ -0,0 +1,61 @@
+package archiver
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// The EFF large wordlist (https://www.eff.org/dice), CC BY 3.0 US: 7776
+// distinct words, one per roll of five dice.
+//
+//go:embed eff-large-wordlist.txt
+var effWordListFile string
+
+var passphraseWords = func() []string {
+	var words []string
+	for _, line := range strings.Split(strings.TrimSpace(effWordListFile), "\n") {
+		if _, word, ok := strings.Cut(line, "\t"); ok {
+			words = append(words, word)
+		}
+	}
+	return words
+}()
+
+// DefaultPassphraseWords gives about 77 bits of entropy.
+const DefaultPassphraseWords = 6
+
+// GeneratePassphrase picks words uniformly at random from the embedded
+// wordlist and joins them with separator. It also returns the entropy in
+// bits, which depends only on the number of words.
+func GeneratePassphrase(words int, separator string) ([]byte, float64, error) {
+	if words < 1 {
+		return nil, 0, errors.New("a passphrase needs at least one word")
+	}
+
+	n := big.NewInt(int64(len(passphraseWords)))
+	// Large enough that append never leaves partial copies behind; no word
+	// in the list is longer than 9 letters.
+	b := make([]byte, 0, words*(9+len(separator)))
+	for i := 0; i < words; i++ {
+		k, err := rand.Int(rand.Reader, n)
+		if err != nil {
+			wipe(b)
+			return nil, 0, err
+		}
+		if i > 0 {
+			b = append(b, separator...)
+		}
+		b = append(b, passphraseWords[k.Int64()]...)
+	}
+	return b, PassphraseEntropy(words), nil
+}
+
+// PassphraseEntropy returns the entropy in bits of a generated passphrase
+// with the given number of words.
+func PassphraseEntropy(words int) float64 {
+	return float64(words) * math.Log2(float64(len(passphraseWords)))
+}
@@ -0,0 +1,549 @@
+package archiver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// A recovery record is appended after the footer. It holds Reed-Solomon
+// parity over fixed-size blocks of everything before it, a CRC of every
+// block so damaged ones can be told apart, and a tail that describes the
+// layout. The tables and the tail are stored twice:
+//
+//	tail | parity blocks | CRC table | CRC table | tail
+//
+// where each tail ends in RecoveryMagic. Version 1 records lack the first
+// tail. Block i belongs to group i mod groups, so a burst of damage is spread
+// over many groups instead of exhausting the parity of one.
+const (
+	RecoveryMagic     = 0x52435652 // "RCVR"
+	recoveryVersion   = 2
+	recoveryBlockSize = 4096
+	// maxRecoveryShards is the most data plus parity shards per group that
+	// Reed-Solomon over GF(2^8) supports.
+	maxRecoveryShards = 256
+	recoveryTailSize  = 1 + 4 + 4 + 2 + 2 + 8 + 4 + 4
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ParseRecoveryPercent parses the --recovery option, e.g. "5%" or "5". The
+// percentage is the parity size relative to the archive.
+func ParseRecoveryPercent(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("invalid recovery size %q (use a percentage such as 5%%)", s)
+	}
+	return percent, nil
+}
+
+type recoveryLayout struct {
+	version      uint8
+	protected    uint64
+	blockSize    uint32
+	groups       uint32
+	dataShards   uint16
+	parityShards uint16
+}
+
+func newRecoveryLayout(protected uint64, percent float64) recoveryLayout {
+	l := recoveryLayout{version: recoveryVersion, protected: protected, blockSize: recoveryBlockSize}
+	blocks := l.blocks()
+
+	maxData := uint64(float64(maxRecoveryShards) / (1 + percent/100))
+	if maxData >= maxRecoveryShards {
+		maxData = maxRecoveryShards - 1
+	}
+	l.groups = uint32(max((blocks+maxData-1)/maxData, 1))
+	data := max((blocks+uint64(l.groups)-1)/uint64(l.groups), 1)
+	parity := uint64(math.Ceil(float64(data) * percent / 100))
+	parity = min(max(parity, 1), maxRecoveryShards-data)
+
+	l.dataShards = uint16(data)
+	l.parityShards = uint16(parity)
+	return l
+}
+
+func (l recoveryLayout) blocks() uint64 {
+	return (l.protected + uint64(l.blockSize) - 1) / uint64(l.blockSize)
+}
+
+func (l recoveryLayout) parityBlocks() uint64 {
+	return uint64(l.groups) * uint64(l.parityShards)
+}
+
+func (l recoveryLayout) tableSize() uint64 {
+	return (l.blocks()+l.parityBlocks())*4 + 4
+}
+
+// parityOffset is where the parity blocks start, after the first tail.
+func (l recoveryLayout) parityOffset() uint64 {
+	if l.version == 1 {
+		return l.protected
+	}
+	return l.protected + recoveryTailSize
+}
+
+// tableOffset is where the first copy of the CRC table starts.
+func (l recoveryLayout) tableOffset() uint64 {
+	return l.parityOffset() + l.parityBlocks()*uint64(l.blockSize)
+}
+
+// size is the length of the whole record, tails included.
+func (l recoveryLayout) size() uint64 {
+	return l.tableOffset() + 2*l.tableSize() + recoveryTailSize - l.protected
+}
+
+// blockOffset returns where shard s of group g lives; parity shards follow
+// the data shards.
+func (l recoveryLayout) blockOffset(g uint32, s int) (offset uint64, isData bool, ok bool) {
+	if s < int(l.dataShards) {
+		i := uint64(s)*uint64(l.groups) + uint64(g)
+		if i >= l.blocks() {
+			return 0, true, false
+		}
+		return i * uint64(l.blockSize), true, true
+	}
+	p := uint64(g)*uint64(l.parityShards) + uint64(s-int(l.dataShards))
+	return l.parityOffset() + p*uint64(l.blockSize), false, true
+}
+
+// crcIndex returns the position of a block's CRC in the table.
+func (l recoveryLayout) crcIndex(g uint32, s int) uint64 {
+	if s < int(l.dataShards) {
+		return uint64(s)*uint64(l.groups) + uint64(g)
+	}
+	return l.blocks() + uint64(g)*uint64(l.parityShards) + uint64(s-int(l.dataShards))
+}
+
+func (l recoveryLayout) encodeTail() []byte {
+	buf := make([]byte, recoveryTailSize)
+	buf[0] = l.version
+	binary.BigEndian.PutUint32(buf[1:5], l.blockSize)
+	binary.BigEndian.PutUint32(buf[5:9], l.groups)
+	binary.BigEndian.PutUint16(buf[9:11], l.dataShards)
+	binary.BigEndian.PutUint16(buf[11:13], l.parityShards)
+	binary.BigEndian.PutUint64(buf[13:21], l.protected)
+	binary.BigEndian.PutUint32(buf[21:25], crc32.Checksum(buf[:21], crcTable))
+	binary.BigEndian.PutUint32(buf[25:29], RecoveryMagic)
+	return buf
+}
+
+var errNoRecoveryRecord = errors.New("archive has no recovery record")
+
+// decodeTail parses a tail and reports whether its checksum and version are
+// valid.
+func decodeTail(buf []byte) (recoveryLayout, bool) {
+	if binary.BigEndian.Uint32(buf[25:29]) != RecoveryMagic ||
+		binary.BigEndian.Uint32(buf[21:25]) != crc32.Checksum(buf[:21], crcTable) ||
+		buf[0] == 0 || buf[0] > recoveryVersion {
+		return recoveryLayout{}, false
+	}
+	return recoveryLayout{
+		version:      buf[0],
+		blockSize:    binary.BigEndian.Uint32(buf[1:5]),
+		groups:       binary.BigEndian.Uint32(buf[5:9]),
+		dataShards:   binary.BigEndian.Uint16(buf[9:11]),
+		parityShards: binary.BigEndian.Uint16(buf[11:13]),
+		protected:    binary.BigEndian.Uint64(buf[13:21]),
+	}, true
+}
+
+// fits reports whether the layout describes a record that ends at size.
+func (l recoveryLayout) fits(size int64) bool {
+	return l.blockSize != 0 && l.groups != 0 && l.dataShards != 0 && l.parityShards != 0 &&
+		int(l.dataShards)+int(l.parityShards) <= maxRecoveryShards &&
+		uint64(l.groups) <= max(l.blocks(), 1) && l.blocks() <= uint64(l.groups)*uint64(l.dataShards) &&
+		l.protected < uint64(size) && l.protected+l.size() == uint64(size)
+}
+
+// readRecoveryLayout reads the tail at the end of r. If it is damaged, the
+// copy at the start of the record is looked for instead. An archive that
+// ends in its footer has no recovery record.
+func readRecoveryLayout(r io.ReadSeeker) (recoveryLayout, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return recoveryLayout{}, err
+	}
+	if size < recoveryTailSize {
+		return recoveryLayout{}, errNoRecoveryRecord
+	}
+	buf := make([]byte, recoveryTailSize)
+	if err := readFullAt(r, buf, size-recoveryTailSize); err != nil {
+		return recoveryLayout{}, err
+	}
+	if l, ok := decodeTail(buf); ok && l.fits(size) {
+		return l, nil
+	}
+	if binary.BigEndian.Uint32(buf[25:29]) == TrailerMagic {
+		return recoveryLayout{}, errNoRecoveryRecord
+	}
+
+	// The first tail starts where the archive ends, so it is the one whose
+	// offset matches the protected size it records. Parity is at most about
+	// as large as the archive, so the record is within the last two thirds.
+	endMagic := binary.BigEndian.Uint32(buf[25:29])
+	var found recoveryLayout
+	_, ok := scanBack(r, size-recoveryTailSize, size/3, magicBytes(RecoveryMagic), func(end int64) bool {
+		start := end - recoveryTailSize
+		if readFullAt(r, buf, start) != nil {
+			return false
+		}
+		l, ok := decodeTail(buf)
+		if ok && l.version > 1 && l.protected == uint64(start) && l.fits(size) {
+			found = l
+			return true
+		}
+		return false
+	})
+	if ok {
+		return found, nil
+	}
+	if endMagic == RecoveryMagic {
+		return recoveryLayout{}, errorf(ErrCorrupt, "recovery record is damaged")
+	}
+	return recoveryLayout{}, errorf(ErrCorrupt, "archive is truncated: trailer is missing")
+}
+
+// archiveSize returns the size of the archive in r without its recovery
+// record, if it has one. If the end of the file is damaged, for example both
+// tails of the record, the end of the archive is found by its footer.
+func archiveSize(r io.ReadSeeker) (int64, error) {
+	l, err := readRecoveryLayout(r)
+	switch {
+	case err == nil:
+		return int64(l.protected), nil
+	case errors.Is(err, errNoRecoveryRecord):
+		return r.Seek(0, io.SeekEnd)
+	case !errors.Is(err, ErrCorrupt):
+		return 0, err
+	}
+	if size, ok := findFooter(r); ok {
+		return size, nil
+	}
+	return 0, err
+}
+
+// findFooter scans backwards for a footer that points at an index and
+// trailer ending right before it, and returns the archive size it implies.
+func findFooter(r io.ReadSeeker) (int64, bool) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, false
+	}
+	h, err := ReadHeader(r)
+	if err != nil {
+		return 0, false
+	}
+	total, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, false
+	}
+	return scanBack(r, total, total/2, magicBytes(TrailerMagic), func(end int64) bool {
+		_, _, _, err := locateIndexAt(r, h, end)
+		return err == nil
+	})
+}
+
+func magicBytes(magic uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, magic)
+}
+
+// scanBack looks for magic in r between from and to, from the end, and
+// returns the offset just past the first match that match accepts.
+func scanBack(r io.ReadSeeker, from, to int64, magic []byte, match func(end int64) bool) (int64, bool) {
+	const chunk = 64 * 1024
+	to = max(to, 0)
+	buf := make([]byte, chunk+len(magic)-1)
+	for hi := from; hi-to >= int64(len(magic)); {
+		lo := max(hi-int64(len(buf)), to)
+		b := buf[:hi-lo]
+		if readFullAt(r, b, lo) != nil {
+			return 0, false
+		}
+		for i := len(b); ; {
+			i = bytes.LastIndex(b[:i], magic)
+			if i < 0 {
+				break
+			}
+			// match may move the file position; b stays valid.
+			if end := lo + int64(i+len(magic)); match(end) {
+				return end, true
+			}
+			i += len(magic) - 1
+		}
+		// Keep an overlap, so a magic across chunks is still found.
+		hi = lo + int64(len(magic)) - 1
+		if lo == to {
+			break
+		}
+	}
+	return 0, false
+}
+
+// readFullAt reads len(buf) bytes at off.
+func readFullAt(r io.ReadSeeker, buf []byte, off int64) error {
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(r, buf)
+	return err
+}
+
+// readShard reads one block, zero-padding the last block of the archive.
+func readShard(f io.ReaderAt, l recoveryLayout, offset uint64, isData bool, shard []byte) error {
+	n := uint64(l.blockSize)
+	if isData && offset+n > l.protected {
+		n = l.protected - offset
+	}
+	clear(shard)
+	_, err := f.ReadAt(shard[:n], int64(offset))
+	if err == io.EOF {
+		err = nil
+	}
+	return err
+}
+
+// WriteRecoveryRecord appends parity covering everything currently in f,
+// sized at percent of it.
+func WriteRecoveryRecord(f randomAccessFile, percent float64) error {
+	protected, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	l := newRecoveryLayout(uint64(protected), percent)
+	enc, err := reedsolomon.New(int(l.dataShards), int(l.parityShards))
+	if err != nil {
+		return err
+	}
+
+	crcs := make([]uint32, l.blocks()+l.parityBlocks())
+	shards := make([][]byte, int(l.dataShards)+int(l.parityShards))
+	for s := range shards {
+		shards[s] = make([]byte, l.blockSize)
+	}
+
+	for g := uint32(0); g < l.groups; g++ {
+		for s := 0; s < int(l.dataShards); s++ {
+			offset, isData, ok := l.blockOffset(g, s)
+			if !ok {
+				clear(shards[s])
+				continue
+			}
+			if err := readShard(f, l, offset, isData, shards[s]); err != nil {
+				return err
+			}
+			crcs[l.crcIndex(g, s)] = crc32.Checksum(shards[s], crcTable)
+		}
+		if err := enc.Encode(shards); err != nil {
+			return err
+		}
+		for s := int(l.dataShards); s < len(shards); s++ {
+			offset, _, _ := l.blockOffset(g, s)
+			if _, err := f.WriteAt(shards[s], int64(offset)); err != nil {
+				return err
+			}
+			crcs[l.crcIndex(g, s)] = crc32.Checksum(shards[s], crcTable)
+		}
+	}
+
+	if _, err := writeRecoveryMeta(f, l, crcs, false); err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekEnd)
+	return err
+}
+
+// recoveryMeta returns both tails and both CRC tables with their offsets.
+func recoveryMeta(l recoveryLayout, crcs []uint32) (offsets []uint64, sections [][]byte) {
+	var table bytes.Buffer
+	binary.Write(&table, binary.BigEndian, crcs)
+	binary.Write(&table, binary.BigEndian, crc32.Checksum(table.Bytes(), crcTable))
+	tail := l.encodeTail()
+
+	if l.version > 1 {
+		offsets = append(offsets, l.protected)
+		sections = append(sections, tail)
+	}
+	offsets = append(offsets, l.tableOffset(), l.tableOffset()+l.tableSize(), l.tableOffset()+2*l.tableSize())
+	sections = append(sections, table.Bytes(), table.Bytes(), tail)
+	return offsets, sections
+}
+
+// writeRecoveryMeta writes the tails and CRC tables that differ from what is
+// stored, which repairs them, and returns how many differed. With dryRun
+// set, it only counts them.
+func writeRecoveryMeta(f randomAccessFile, l recoveryLayout, crcs []uint32, dryRun bool) (int, error) {
+	offsets, sections := recoveryMeta(l, crcs)
+	damaged := 0
+	for i, section := range sections {
+		stored := make([]byte, len(section))
+		if _, err := f.ReadAt(stored, int64(offsets[i])); err == nil && bytes.Equal(stored, section) {
+			continue
+		}
+		damaged++
+		if dryRun {
+			continue
+		}
+		if _, err := f.WriteAt(section, int64(offsets[i])); err != nil {
+			return damaged, err
+		}
+	}
+	return damaged, nil
+}
+
+// readCRCTable returns the first intact copy of the CRC table.
+func readCRCTable(f io.ReaderAt, l recoveryLayout) ([]uint32, error) {
+	offset := l.tableOffset()
+	buf := make([]byte, l.tableSize())
+	for c := range 2 {
+		if _, err := f.ReadAt(buf, int64(offset+uint64(c)*l.tableSize())); err != nil {
+			return nil, err
+		}
+		body := buf[:len(buf)-4]
+		if binary.BigEndian.Uint32(buf[len(buf)-4:]) != crc32.Checksum(body, crcTable) {
+			continue
+		}
+		crcs := make([]uint32, len(body)/4)
+		for i := range crcs {
+			crcs[i] = binary.BigEndian.Uint32(body[4*i:])
+		}
+		return crcs, nil
+	}
+	return nil, errorf(ErrCorrupt, "recovery record is damaged: both block checksum tables are corrupt")
+}
+
+// RepairReport is the result of RepairArchive.
+type RepairReport struct {
+	Blocks int
+	// Damaged counts blocks whose checksum did not match, parity included,
+	// and damaged copies of the record's tail and CRC table.
+	Damaged int
+	// Repaired counts damaged blocks that were reconstructed.
+	Repaired int
+	// Unrecoverable counts damaged blocks in groups with more damage than
+	// parity; the archive is still damaged if this is not zero.
+	Unrecoverable int
+}
+
+// RepairArchive finds damaged blocks through the recovery record and, unless
+// dryRun is set, rewrites them in place from the parity.
+func RepairArchive(archiveFile string, dryRun bool) (*RepairReport, error) {
+	mode := os.O_RDWR
+	if dryRun {
+		mode = os.O_RDONLY
+	}
+	f, err := openArchiveFile(archiveFile, mode)
+	if err != nil {
+		return nil, err
+	}
+	report, err := repairFile(f, dryRun)
+	// Closing can report a failed write, so it is checked unless there
+	// already was an error.
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// repairFile is RepairArchive for an open archive or volume set.
+func repairFile(f randomAccessFile, dryRun bool) (*RepairReport, error) {
+	l, err := readRecoveryLayout(f)
+	if err != nil {
+		return nil, err
+	}
+	crcs, err := readCRCTable(f, l)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := reedsolomon.New(int(l.dataShards), int(l.parityShards))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RepairReport{Blocks: int(l.blocks() + l.parityBlocks())}
+	shards := make([][]byte, int(l.dataShards)+int(l.parityShards))
+	buffers := make([][]byte, len(shards))
+	for s := range buffers {
+		buffers[s] = make([]byte, l.blockSize)
+	}
+
+	for g := uint32(0); g < l.groups; g++ {
+		var damaged []int
+		for s := range shards {
+			shards[s] = buffers[s]
+			offset, isData, ok := l.blockOffset(g, s)
+			if !ok {
+				clear(shards[s])
+				continue
+			}
+			if err := readShard(f, l, offset, isData, shards[s]); err != nil {
+				return nil, err
+			}
+			if crc32.Checksum(shards[s], crcTable) != crcs[l.crcIndex(g, s)] {
+				damaged = append(damaged, s)
+				shards[s] = shards[s][:0]
+			}
+		}
+		if len(damaged) == 0 {
+			continue
+		}
+		report.Damaged += len(damaged)
+		if len(damaged) > int(l.parityShards) {
+			report.Unrecoverable += len(damaged)
+			continue
+		}
+
+		if err := enc.Reconstruct(shards); err != nil {
+			return nil, err
+		}
+		for _, s := range damaged {
+			if crc32.Checksum(shards[s], crcTable) != crcs[l.crcIndex(g, s)] {
+				report.Unrecoverable++
+				continue
+			}
+			if !dryRun {
+				offset, isData, _ := l.blockOffset(g, s)
+				n := uint64(l.blockSize)
+				if isData && offset+n > l.protected {
+					n = l.protected - offset
+				}
+				if _, err := f.WriteAt(shards[s][:n], int64(offset)); err != nil {
+					return nil, err
+				}
+			}
+			report.Repaired++
+		}
+	}
+
+	// A damaged tail or CRC table has an intact copy that was used above.
+	damaged, err := writeRecoveryMeta(f, l, crcs, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	report.Damaged += damaged
+	report.Repaired += damaged
+
+	if !dryRun {
+		if err := f.Sync(); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
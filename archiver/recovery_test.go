@@ -0,0 +1,192 @@
+package archiver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// corrupt flips n bytes of file starting at offset.
+func corrupt(t *testing.T, file string, offset, n int) {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := offset; i < offset+n; i++ {
+		data[i] ^= 0xff
+	}
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkExtract extracts archive and compares the file to want.
+func checkExtract(t *testing.T, archive string, want []byte) {
+	t.Helper()
+	dir := t.TempDir()
+	if _, err := ExtractArchive(testCredentials(), testSalt, archive, dir); err != nil {
+		t.Fatalf("ExtractArchive: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "input.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("extracted file differs from the original")
+	}
+}
+
+func TestRepairArchive(t *testing.T) {
+	for _, n := range []int{1, 100, recoveryBlockSize, 3 * recoveryBlockSize} {
+		data := randomBytes(t, 200*1024)
+		archive := createTestArchive(t, data, ArchiveOptions{Recovery: 10})
+		original, err := os.ReadFile(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		corrupt(t, archive, headerSize+5000, n)
+		if _, err := ExtractArchive(testCredentials(), testSalt, archive, t.TempDir()); err == nil {
+			t.Fatalf("%d bytes: ExtractArchive succeeded on a damaged archive", n)
+		}
+
+		report, err := RepairArchive(archive, true)
+		if err != nil {
+			t.Fatalf("%d bytes: RepairArchive(dry run): %v", n, err)
+		}
+		if report.Damaged == 0 || report.Unrecoverable != 0 {
+			t.Fatalf("%d bytes: dry run report %+v", n, report)
+		}
+		if damaged, _ := os.ReadFile(archive); bytes.Equal(damaged, original) {
+			t.Fatalf("%d bytes: dry run changed the archive", n)
+		}
+
+		report, err = RepairArchive(archive, false)
+		if err != nil {
+			t.Fatalf("%d bytes: RepairArchive: %v", n, err)
+		}
+		if report.Repaired != report.Damaged || report.Unrecoverable != 0 {
+			t.Fatalf("%d bytes: report %+v", n, report)
+		}
+		repaired, err := os.ReadFile(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(repaired, original) {
+			t.Fatalf("%d bytes: repaired archive differs from the original", n)
+		}
+		checkExtract(t, archive, data)
+	}
+}
+
+func TestRepairArchiveTooMuchDamage(t *testing.T) {
+	archive := createTestArchive(t, randomBytes(t, 200*1024), ArchiveOptions{Recovery: 5})
+	corrupt(t, archive, headerSize, 100*1024)
+
+	report, err := RepairArchive(archive, false)
+	if err != nil {
+		t.Fatalf("RepairArchive: %v", err)
+	}
+	if report.Unrecoverable == 0 {
+		t.Fatalf("report %+v, want unrecoverable blocks", report)
+	}
+}
+
+func TestRepairArchiveVolumes(t *testing.T) {
+	data := randomBytes(t, 200*1024)
+	archive := createTestArchive(t, data, ArchiveOptions{Recovery: 10, VolumeSize: MinVolumeSize})
+	// Damage the start of the second volume's data.
+	corrupt(t, VolumePath(archive, 2), volumeHeaderSize, 200)
+
+	report, err := RepairArchive(archive, false)
+	if err != nil {
+		t.Fatalf("RepairArchive: %v", err)
+	}
+	if report.Damaged == 0 || report.Repaired != report.Damaged {
+		t.Fatalf("report %+v", report)
+	}
+	checkExtract(t, archive, data)
+}
+
+func TestRepairArchiveDamagedTail(t *testing.T) {
+	for _, fromEnd := range []int{1, 10, recoveryTailSize} {
+		data := randomBytes(t, 100*1024)
+		archive := createTestArchive(t, data, ArchiveOptions{Recovery: 5})
+		original, err := os.ReadFile(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		corrupt(t, archive, len(original)-fromEnd, 1)
+
+		if _, err := ListArchive(testCredentials(), testSalt, archive); err != nil {
+			t.Fatalf("size-%d: ListArchive: %v", fromEnd, err)
+		}
+		report, err := RepairArchive(archive, false)
+		if err != nil {
+			t.Fatalf("size-%d: RepairArchive: %v", fromEnd, err)
+		}
+		if report.Damaged != 1 || report.Repaired != 1 {
+			t.Fatalf("size-%d: report %+v", fromEnd, report)
+		}
+		repaired, err := os.ReadFile(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(repaired, original) {
+			t.Fatalf("size-%d: repaired archive differs from the original", fromEnd)
+		}
+	}
+}
+
+func TestDamagedRecoveryTails(t *testing.T) {
+	data := randomBytes(t, 100*1024)
+	archive := createTestArchive(t, data, ArchiveOptions{Recovery: 5})
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := readRecoveryLayout(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt(t, archive, int(l.protected)+5, 1)
+	corrupt(t, archive, int(info.Size())-5, 1)
+
+	// The archive is found through its footer, but can't be repaired.
+	checkExtract(t, archive, data)
+	if _, err := RepairArchive(archive, true); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("RepairArchive = %v, want %v", err, ErrCorrupt)
+	}
+}
+
+func TestTruncatedArchive(t *testing.T) {
+	archive := createTestArchive(t, randomBytes(t, 10*1024), ArchiveOptions{})
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archive, data[:len(data)-3], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ListArchive(testCredentials(), testSalt, archive); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("ListArchive = %v, want %v", err, ErrCorrupt)
+	}
+}
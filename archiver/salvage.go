@@ -0,0 +1,386 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SalvageReportName is the file SalvageArchive writes its report to.
+const SalvageReportName = "salvage-report.txt"
+
+// salvageWindow is how many entry positions past the last one found are
+// searched for when the index is unavailable.
+const salvageWindow = 4096
+
+// SalvageReport is the result of SalvageArchive.
+type SalvageReport struct {
+	// Recovered lists the entries written to the output directory, in
+	// archive order.
+	Recovered []Entry
+	// Lost lists the entries that could not be recovered. Name is empty if
+	// neither the index nor the entry's local header survived.
+	Lost []*EntryError
+	// IndexErr is set if the index could not be read, in which case entries
+	// after the last one found cannot be accounted for.
+	IndexErr error
+	// MissingVolumes names the volumes of a split archive that were not
+	// found.
+	MissingVolumes []string
+}
+
+// OK reports whether every entry was recovered.
+func (s *SalvageReport) OK() bool {
+	return len(s.Lost) == 0 && s.IndexErr == nil && len(s.MissingVolumes) == 0
+}
+
+// WriteTo writes the report as text.
+func (s *SalvageReport) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Recovered %d entries, lost %d.\n", len(s.Recovered), len(s.Lost))
+	for _, name := range s.MissingVolumes {
+		fmt.Fprintf(&buf, "Volume %s is missing.\n", name)
+	}
+	if s.IndexErr != nil {
+		fmt.Fprintf(&buf, "The index could not be read (%v); entries after the last one found may be missing from this report.\n", s.IndexErr)
+	}
+	if len(s.Recovered) > 0 {
+		fmt.Fprintln(&buf, "\nRecovered:")
+		for _, e := range s.Recovered {
+			fmt.Fprintf(&buf, "  %s (%d bytes)\n", e.Name, e.OriginalSize)
+		}
+	}
+	if len(s.Lost) > 0 {
+		fmt.Fprintln(&buf, "\nLost:")
+		for _, e := range s.Lost {
+			name := e.Name
+			if name == "" {
+				name = "name unknown"
+			}
+			fmt.Fprintf(&buf, "  entry %d (%s): %v\n", e.Index, name, e.Err)
+		}
+	}
+	return buf.WriteTo(w)
+}
+
+// SalvageArchive recovers what it can from a damaged archive. Instead of
+// trusting the index it scans the whole file for the sync markers that
+// precede each entry, so entries survive damage to the index, the trailer or
+// other entries. Every entry that still authenticates is written to
+// outputDir, followed by a report of what was lost. Only the header has to
+// be intact; a hidden archive also needs its hidden slot.
+func SalvageArchive(creds Credentials, saltHex, archiveFile, outputDir string) (*SalvageReport, error) {
+	if err := creds.Validate(); err != nil {
+		return nil, err
+	}
+
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return nil, err
+	}
+
+	// Missing volumes read as zeros, so the entries in the others survive.
+	var inFile randomAccessFile
+	var missingVolumes []string
+	if isVolumeSet(archiveFile) {
+		volumes, err := openVolumes(archiveFile, os.O_RDONLY, true)
+		if err != nil {
+			return nil, err
+		}
+		inFile, missingVolumes = volumes, volumes.missingVolumes()
+	} else if inFile, err = os.Open(archiveFile); err != nil {
+		return nil, err
+	}
+	defer inFile.Close()
+
+	outer, err := ReadHeader(inFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot salvage without an intact header: %w", err)
+	}
+	if err := outer.CheckCredentials(creds); err != nil {
+		return nil, err
+	}
+
+	masterKey, err := GenerateKey(creds, salt, outer.KDF)
+	if err != nil {
+		return nil, err
+	}
+	defer masterKey.Wipe()
+
+	// Work out which archive the credentials belong to. The index is only
+	// needed for the names and number of lost entries.
+	header := outer
+	report := &SalvageReport{MissingVolumes: missingVolumes}
+	var known []Entry
+	keys, err := DeriveArchiveKeys(masterKey, outer)
+	if err != nil {
+		return nil, err
+	}
+	index, err := ReadIndex(inFile, keys, outer)
+	if err != nil {
+		report.IndexErr = err
+		if s, slotErr := readHiddenSlot(inFile, masterKey, outer); slotErr == nil {
+			keys.Wipe()
+			header = s.header(outer)
+			if keys, err = DeriveArchiveKeys(masterKey, header); err != nil {
+				return nil, err
+			}
+			if hiddenIndex, hiddenKeys, err := readHiddenIndex(inFile, masterKey, outer); err == nil {
+				hiddenKeys.Wipe()
+				index, report.IndexErr = hiddenIndex, nil
+			} else {
+				report.IndexErr = err
+			}
+		}
+	}
+	defer keys.Wipe()
+	if index != nil {
+		known = index.Entries
+	}
+
+	// The end of the archive is only a bound for the scan, so if the
+	// recovery record and the footer are both gone the whole file will do.
+	sc := &salvageScanner{r: inFile, h: header, keys: keys}
+	if sc.size, err = archiveSize(inFile); err != nil {
+		if sc.size, err = inFile.Seek(0, io.SeekEnd); err != nil {
+			return nil, err
+		}
+	}
+	found, err := sc.scan(len(known))
+	if err != nil {
+		return nil, err
+	}
+	// Without the index and without a single marker, the password is most
+	// likely wrong.
+	if index == nil && len(found) == 0 {
+		return nil, fmt.Errorf("no entries found: %w", report.IndexErr)
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	names := make(map[string]bool)
+	recovered := make(map[int]bool)
+	failed := make(map[int]salvagedEntry)
+	last := len(known) - 1
+	for _, f := range found {
+		last = max(last, f.index)
+		if recovered[f.index] {
+			continue
+		}
+		if f.err != nil {
+			if _, ok := failed[f.index]; !ok {
+				failed[f.index] = f
+			}
+			continue
+		}
+		if err := writeSalvaged(outputDir, f, names); err != nil {
+			return nil, err
+		}
+		report.Recovered = append(report.Recovered, f.entry)
+		recovered[f.index] = true
+	}
+
+	for i := 0; i <= last; i++ {
+		if recovered[i] {
+			continue
+		}
+		e := &EntryError{Index: i, Err: errors.New("sync marker not found")}
+		if f, ok := failed[i]; ok {
+			e.Name, e.Err = f.entry.Name, f.err
+		}
+		if i < len(known) {
+			e.Name = known[i].Name
+		}
+		report.Lost = append(report.Lost, e)
+	}
+
+	reportFile, err := os.Create(filepath.Join(outputDir, SalvageReportName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to write salvage report: %v", err)
+	}
+	if _, err := report.WriteTo(reportFile); err != nil {
+		reportFile.Close()
+		return nil, fmt.Errorf("failed to write salvage report: %v", err)
+	}
+	if err := reportFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write salvage report: %v", err)
+	}
+	return report, nil
+}
+
+// writeSalvaged writes a recovered entry under its base name, or under its
+// position if the name is unusable or already taken.
+func writeSalvaged(outputDir string, f salvagedEntry, names map[string]bool) error {
+	name := f.entry.Name
+	switch {
+	case checkEntryName(name) != nil:
+		name = fmt.Sprintf("entry-%d", f.index)
+	case name == SalvageReportName || names[name]:
+		name = fmt.Sprintf("entry-%d-%s", f.index, name)
+	}
+	names[name] = true
+
+	outputPath := filepath.Join(outputDir, name)
+	if err := os.WriteFile(outputPath, f.data, 0644); err != nil {
+		return fmt.Errorf("failed to write file %s: %v", outputPath, err)
+	}
+	return nil
+}
+
+type salvagedEntry struct {
+	index int
+	entry Entry
+	data  []byte
+	err   error
+}
+
+type markerRef struct {
+	marker [syncMarkerSize]byte
+	mask   uint32
+	index  uint32
+}
+
+// salvageScanner looks for the sync markers of one archive. Markers are
+// generated for a window of positions that grows as entries are found.
+type salvageScanner struct {
+	r       io.ReadSeeker
+	h       *Header
+	keys    *ArchiveKeys
+	size    int64
+	markers map[uint32][]markerRef
+	limit   uint32
+}
+
+func (sc *salvageScanner) extend(limit uint32) {
+	if sc.markers == nil {
+		sc.markers = make(map[uint32][]markerRef)
+	}
+	for i := sc.limit; i < limit; i++ {
+		marker, mask := sc.keys.syncMarker(i)
+		key := binary.BigEndian.Uint32(marker[:4])
+		sc.markers[key] = append(sc.markers[key], markerRef{marker: marker, mask: mask, index: i})
+	}
+	sc.limit = limit
+}
+
+func (sc *salvageScanner) match(window []byte) (markerRef, bool) {
+	for _, ref := range sc.markers[binary.BigEndian.Uint32(window[:4])] {
+		if bytes.Equal(ref.marker[:], window[:syncMarkerSize]) {
+			return ref, true
+		}
+	}
+	return markerRef{}, false
+}
+
+// scan reads the file once from the end of the header and tries every
+// marker it finds. expected is the number of entries in the index, if known.
+func (sc *salvageScanner) scan(expected int) ([]salvagedEntry, error) {
+	sc.extend(uint32(max(expected, salvageWindow)))
+
+	var found []salvagedEntry
+	pos := int64(headerSize)
+	for pos+syncMarkerSize <= sc.size {
+		if _, err := sc.r.Seek(pos, io.SeekStart); err != nil {
+			return nil, err
+		}
+		br := bufio.NewReaderSize(io.LimitReader(sc.r, sc.size-pos), 1<<20)
+
+		next := int64(-1)
+		for {
+			window, err := br.Peek(syncMarkerSize)
+			if err != nil {
+				break
+			}
+			if ref, ok := sc.match(window); ok {
+				f, end := sc.open(pos, ref)
+				found = append(found, f)
+				if ref.index+salvageWindow/2 >= sc.limit {
+					sc.extend(ref.index + salvageWindow)
+				}
+				if f.err == nil {
+					next = end
+				} else {
+					next = pos + syncMarkerSize
+				}
+				break
+			}
+			br.Discard(1)
+			pos++
+		}
+		if next < 0 {
+			break
+		}
+		pos = next
+	}
+	return found, nil
+}
+
+// open reads the entry whose marker is at pos and returns it along with
+// where its data ends.
+func (sc *salvageScanner) open(pos int64, ref markerRef) (salvagedEntry, int64) {
+	f := salvagedEntry{index: int(ref.index)}
+	fail := func(err error) (salvagedEntry, int64) {
+		f.err = err
+		return f, 0
+	}
+
+	if _, err := sc.r.Seek(pos+syncMarkerSize, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	var masked uint32
+	if err := binary.Read(sc.r, binary.BigEndian, &masked); err != nil {
+		return fail(errorf(ErrCorrupt, "entry is truncated"))
+	}
+	overhead, err := sc.h.Cipher.overhead()
+	if err != nil {
+		return fail(err)
+	}
+	localSize := int64(masked ^ ref.mask)
+	minSize := int64(overhead + encodedEntrySize(&Entry{}))
+	if localSize < minSize || localSize > minSize+0xffff || pos+syncMarkerSize+4+localSize > sc.size {
+		return fail(errors.New("local header is corrupted"))
+	}
+	sealed := make([]byte, localSize)
+	if _, err := io.ReadFull(sc.r, sealed); err != nil {
+		return fail(errorf(ErrCorrupt, "entry is truncated"))
+	}
+
+	entryKey, err := sc.keys.EntryKey(ref.index)
+	if err != nil {
+		return fail(err)
+	}
+	defer wipe(entryKey)
+	local, err := Decrypt(sc.h.Cipher, sealed, entryKey, localHeaderAAD(sc.h, ref.index))
+	if err != nil {
+		return fail(errorf(ErrCorrupt, "local header failed authentication"))
+	}
+	lr := bytes.NewReader(local)
+	if f.entry, err = decodeEntry(lr); err != nil || lr.Len() != 0 {
+		return fail(errors.New("local header is corrupted"))
+	}
+
+	dataStart := pos + syncMarkerSize + 4 + localSize
+	if f.entry.StoredSize > uint64(sc.size-dataStart) {
+		return fail(errorf(ErrCorrupt, "entry is truncated"))
+	}
+	encryptedData := make([]byte, f.entry.StoredSize)
+	if _, err := io.ReadFull(sc.r, encryptedData); err != nil {
+		return fail(errorf(ErrCorrupt, "entry is truncated"))
+	}
+	decryptedData, err := Decrypt(sc.h.Cipher, encryptedData, entryKey, EntryAAD(sc.h, ref.index, &f.entry))
+	if err != nil {
+		return fail(errorf(ErrCorrupt, "failed authentication: %v", err))
+	}
+	if f.data, err = RestoreEntryData(sc.h, decryptedData, &f.entry); err != nil {
+		return fail(err)
+	}
+	return f, dataStart + int64(f.entry.StoredSize)
+}
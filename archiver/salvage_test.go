@@ -0,0 +1,57 @@
+package archiver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSalvageArchive(t *testing.T) {
+	contents := [][]byte{randomBytes(t, 20000), randomBytes(t, 20000), randomBytes(t, 20000)}
+	archive := createTestArchiveFiles(t, contents, ArchiveOptions{})
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Damage the data of the second entry and the index, then cut off the
+	// footer so that the end of the archive is unknown too.
+	indexOffset := binary.BigEndian.Uint64(data[len(data)-footerSize:])
+	corrupt(t, archive, headerSize+30000, 1)
+	corrupt(t, archive, int(indexOffset)+20, 1)
+	if err := os.Truncate(archive, int64(len(data)-4)); err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := t.TempDir()
+	report, err := SalvageArchive(testCredentials(), testSalt, archive, outputDir)
+	if err != nil {
+		t.Fatalf("SalvageArchive: %v", err)
+	}
+	if report.OK() || report.IndexErr == nil {
+		t.Fatalf("report = %+v, want a lost entry and an index error", report)
+	}
+	if len(report.Recovered) != 2 || report.Recovered[0].Name != "file0.bin" || report.Recovered[1].Name != "file2.bin" {
+		t.Fatalf("recovered %+v, want file0.bin and file2.bin", report.Recovered)
+	}
+	if len(report.Lost) != 1 || report.Lost[0].Index != 1 || report.Lost[0].Name != "file1.bin" {
+		t.Fatalf("lost %+v, want entry 1 (file1.bin)", report.Lost)
+	}
+	for _, i := range []int{0, 2} {
+		got, err := os.ReadFile(filepath.Join(outputDir, report.Recovered[i/2].Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, contents[i]) {
+			t.Errorf("file%d.bin differs from the original", i)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "file1.bin")); !os.IsNotExist(err) {
+		t.Errorf("damaged entry was written: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, SalvageReportName)); err != nil {
+		t.Errorf("report not written: %v", err)
+	}
+}
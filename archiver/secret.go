@@ -0,0 +1,28 @@
+package archiver
+
+import "runtime"
+
+// Key holds secret key material until Wipe is called. Archive operations wipe
+// every key they derive before returning, so keys don't outlive their use.
+type Key struct {
+	b []byte
+}
+
+// Bytes returns the key itself, not a copy. It is empty after Wipe.
+func (k *Key) Bytes() []byte {
+	return k.b
+}
+
+func (k *Key) Wipe() {
+	if k == nil {
+		return
+	}
+	wipe(k.b)
+	k.b = nil
+}
+
+// wipe overwrites b with zeros.
+func wipe(b []byte) {
+	clear(b)
+	runtime.KeepAlive(b)
+}
@@ -0,0 +1,135 @@
+package archiver
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const signatureSize = ed25519.PublicKeySize + ed25519.SignatureSize
+
+// signedMessage is what the archive signature covers: a digest of every byte
+// written before the signature section.
+func signedMessage(archiveDigest []byte) []byte {
+	return append([]byte("seaf signature"), archiveDigest...)
+}
+
+// WriteSignature signs the archive digest and writes the signer's public key
+// followed by the signature.
+func WriteSignature(w io.Writer, key ed25519.PrivateKey, archiveDigest []byte) error {
+	publicKey := key.Public().(ed25519.PublicKey)
+	if _, err := w.Write(publicKey); err != nil {
+		return err
+	}
+	_, err := w.Write(ed25519.Sign(key, signedMessage(archiveDigest)))
+	return err
+}
+
+// VerifySignature checks that the archive was signed by signer. It needs no
+// password: the signature covers the encrypted bytes as stored.
+func VerifySignature(archiveFile string, signer ed25519.PublicKey) error {
+	f, err := openArchiveFile(archiveFile, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header, err := ReadHeader(f)
+	if err != nil {
+		return err
+	}
+	if !header.Signed() {
+		return errorf(ErrBadSignature, "archive is not signed")
+	}
+
+	size, err := archiveSize(f)
+	if err != nil {
+		return err
+	}
+	signatureOffset := size - footerSize - signatureSize
+	if signatureOffset < headerSize {
+		return errorf(ErrCorrupt, "archive is truncated: signature is missing")
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	digest := sha256.New()
+	if _, err := io.CopyN(digest, f, signatureOffset); err != nil {
+		return err
+	}
+
+	section := make([]byte, signatureSize)
+	if _, err := io.ReadFull(f, section); err != nil {
+		return err
+	}
+	publicKey := ed25519.PublicKey(section[:ed25519.PublicKeySize])
+	if !bytes.Equal(publicKey, signer) {
+		return errorf(ErrBadSignature, "archive was signed by a different key (%s)", KeyFingerprint(publicKey))
+	}
+	if !ed25519.Verify(publicKey, signedMessage(digest.Sum(nil)), section[ed25519.PublicKeySize:]) {
+		return errorf(ErrBadSignature, "archive signature is invalid")
+	}
+	return nil
+}
+
+// KeyFingerprint returns a short, printable identifier for a public key.
+func KeyFingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// LoadSigningKey reads an Ed25519 private key in PKCS#8 PEM form, as written
+// by "openssl genpkey -algorithm ed25519".
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 private key", path)
+	}
+	return privateKey, nil
+}
+
+// LoadPublicKey accepts a PKIX PEM file, or the key itself as 64 hex digits.
+func LoadPublicKey(pathOrHex string) (ed25519.PublicKey, error) {
+	if raw, err := hex.DecodeString(strings.TrimSpace(pathOrHex)); err == nil && len(raw) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(raw), nil
+	}
+
+	data, err := os.ReadFile(pathOrHex)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", pathOrHex)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", pathOrHex, err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 public key", pathOrHex)
+	}
+	return publicKey, nil
+}
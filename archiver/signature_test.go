@@ -0,0 +1,98 @@
+package archiver
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func testSigningKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSignature(t *testing.T) {
+	key := testSigningKey(t)
+	other := testSigningKey(t).Public().(ed25519.PublicKey)
+	public := key.Public().(ed25519.PublicKey)
+
+	tests := []struct {
+		recovery   float64
+		volumeSize uint64
+	}{
+		{0, 0},
+		{5, 0},
+		{0, 64 * 1024},
+		{5, 64 * 1024},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("recovery %v volume size %d", tt.recovery, tt.volumeSize), func(t *testing.T) {
+			data := randomBytes(t, 200*1024)
+			archive := createTestArchive(t, data, ArchiveOptions{
+				SigningKey: key,
+				Recovery:   tt.recovery,
+				VolumeSize: tt.volumeSize,
+			})
+			if err := VerifySignature(archive, public); err != nil {
+				t.Fatalf("VerifySignature: %v", err)
+			}
+			if err := VerifySignature(archive, other); !errors.Is(err, ErrBadSignature) {
+				t.Fatalf("VerifySignature(wrong key) = %v, want %v", err, ErrBadSignature)
+			}
+			checkExtract(t, archive, data)
+		})
+	}
+}
+
+func TestTamperedSignature(t *testing.T) {
+	key := testSigningKey(t)
+	public := key.Public().(ed25519.PublicKey)
+	archive := createTestArchive(t, randomBytes(t, 10*1024), ArchiveOptions{SigningKey: key})
+	original, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, offset := range map[string]int{
+		"signature":  len(original) - footerSize - 1,
+		"public key": len(original) - footerSize - signatureSize,
+		"data":       headerSize + 100,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := os.WriteFile(archive, original, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			corrupt(t, archive, offset, 1)
+			if err := VerifySignature(archive, public); !errors.Is(err, ErrBadSignature) {
+				t.Fatalf("VerifySignature = %v, want %v", err, ErrBadSignature)
+			}
+		})
+	}
+}
+
+// TestRepairSignedArchive checks that the recovery record restores the bytes
+// the signature covers.
+func TestRepairSignedArchive(t *testing.T) {
+	key := testSigningKey(t)
+	public := key.Public().(ed25519.PublicKey)
+	data := randomBytes(t, 100*1024)
+	archive := createTestArchive(t, data, ArchiveOptions{SigningKey: key, Recovery: 5})
+
+	corrupt(t, archive, headerSize+1000, 16)
+	if err := VerifySignature(archive, public); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("VerifySignature(damaged) = %v, want %v", err, ErrBadSignature)
+	}
+	if _, err := RepairArchive(archive, false); err != nil {
+		t.Fatalf("RepairArchive: %v", err)
+	}
+	if err := VerifySignature(archive, public); err != nil {
+		t.Fatalf("VerifySignature(repaired): %v", err)
+	}
+	checkExtract(t, archive, data)
+}
@@ -0,0 +1,384 @@
+package archiver
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed common-passwords.txt
+var commonPasswordList string
+
+// commonPasswords maps each common password to its rank, starting at 1.
+var commonPasswords = func() map[string]int {
+	m := make(map[string]int)
+	for i, word := range strings.Fields(commonPasswordList) {
+		if _, ok := m[word]; !ok {
+			m[word] = i + 1
+		}
+	}
+	return m
+}()
+
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+	"qwertzuiop",
+	"yxcvbnm",
+	"azertyuiop",
+	"wxcvbn",
+}
+
+var leetSubstitutions = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i',
+	'!': 'i', '|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+// PasswordStrength is the result of EstimateStrength.
+type PasswordStrength struct {
+	// Score runs from 0 (trivially guessable) to 4 (very strong).
+	Score int
+	// Guesses estimates how many attempts an attacker who knows common
+	// password patterns needs.
+	Guesses float64
+	// Warning names the weakest pattern found, if any.
+	Warning string
+}
+
+var scoreLabels = []string{"very weak", "weak", "fair", "strong", "very strong"}
+
+// ScoreLabel describes a score in words.
+func ScoreLabel(score int) string {
+	if score < 0 || score >= len(scoreLabels) {
+		return "unknown"
+	}
+	return scoreLabels[score]
+}
+
+// ParseScore accepts a score as a number from 0 to 4 or as its label.
+func ParseScore(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, label := range scoreLabels {
+		if s == label || s == fmt.Sprint(i) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid password score %q (use 0-4 or %s)", s, strings.Join(scoreLabels, ", "))
+}
+
+func (s PasswordStrength) String() string {
+	if s.Warning == "" {
+		return ScoreLabel(s.Score)
+	}
+	return ScoreLabel(s.Score) + ": " + s.Warning
+}
+
+// DefaultMinPasswordScore is the weakest password accepted for new archives
+// unless the caller lowers it.
+const DefaultMinPasswordScore = 3
+
+// ErrWeakPassword is returned by CheckPasswordStrength.
+var ErrWeakPassword = errors.New("password is too weak")
+
+// CheckPasswordStrength returns ErrWeakPassword, with the reason, if the
+// password scores below minScore.
+func CheckPasswordStrength(password []byte, minScore int) error {
+	s := EstimateStrength(password)
+	if s.Score >= minScore {
+		return nil
+	}
+	return fmt.Errorf("%w: %s (need at least %s)", ErrWeakPassword, s, ScoreLabel(minScore))
+}
+
+const maxStrengthRunes = 100
+
+// passwordMatch is a substring of the password that follows a known pattern.
+type passwordMatch struct {
+	start, end int
+	guesses    float64
+	warning    string
+}
+
+// EstimateStrength estimates how hard the password is to guess, in the
+// manner of zxcvbn: it finds common passwords (also reversed or with leet
+// substitutions), keyboard walks, sequences, repeats and years, then takes
+// the cheapest way to cover the password with those patterns and brute force.
+// The password is never converted to a string; the copies it works on are
+// wiped before it returns.
+func EstimateStrength(password []byte) PasswordStrength {
+	runes := decodeRunes(password, maxStrengthRunes)
+	defer clear(runes)
+	if len(runes) == 0 {
+		return PasswordStrength{Warning: "the password is empty"}
+	}
+	n := len(runes)
+	lower := make([]rune, n)
+	defer clear(lower)
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	var matches []passwordMatch
+	matches = append(matches, dictionaryMatches(runes, lower)...)
+	matches = append(matches, keyboardMatches(lower)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, repeatMatches(runes)...)
+	matches = append(matches, yearMatches(runes)...)
+
+	// best[i] is the fewest guesses covering runes[:i]; each extra segment
+	// costs a little, since the attacker has to try combinations.
+	const bruteForcePerChar = 10
+	best := make([]float64, n+1)
+	warning := make([]string, n+1)
+	for i := 1; i <= n; i++ {
+		best[i] = best[i-1] * bruteForcePerChar
+		if i == 1 {
+			best[i] = bruteForcePerChar
+		}
+		warning[i] = warning[i-1]
+		for _, m := range matches {
+			if m.end != i {
+				continue
+			}
+			g := m.guesses
+			if m.start > 0 {
+				g *= best[m.start] * 2
+			}
+			if g < best[i] {
+				best[i] = g
+				warning[i] = m.warning
+			}
+		}
+	}
+
+	s := PasswordStrength{Guesses: best[n], Warning: warning[n]}
+	switch {
+	case s.Guesses < 1e3+5:
+		s.Score = 0
+	case s.Guesses < 1e6+5:
+		s.Score = 1
+	case s.Guesses < 1e8+5:
+		s.Score = 2
+	case s.Guesses < 1e10+5:
+		s.Score = 3
+	default:
+		s.Score = 4
+	}
+	if s.Score < DefaultMinPasswordScore && s.Warning == "" {
+		s.Warning = "add more words or characters"
+	}
+	return s
+}
+
+func dictionaryMatches(runes, lower []rune) []passwordMatch {
+	unleet := make([]rune, len(lower))
+	defer clear(unleet)
+	for i, r := range lower {
+		if sub, ok := leetSubstitutions[r]; ok {
+			unleet[i] = sub
+		} else {
+			unleet[i] = r
+		}
+	}
+
+	// Candidates are encoded into buf for the lookups; indexing a map with
+	// string(buf) does not copy it.
+	var buf []byte
+	defer func() { clear(buf) }()
+	lookup := func(word []rune, reversed bool) (int, bool) {
+		buf = buf[:0]
+		for k := range word {
+			if reversed {
+				k = len(word) - 1 - k
+			}
+			buf = utf8.AppendRune(buf, word[k])
+		}
+		rank, ok := commonPasswords[string(buf)]
+		return rank, ok
+	}
+
+	var matches []passwordMatch
+	for i := range lower {
+		for j := i + 3; j <= len(lower); j++ {
+			variations := 1.0
+			if hasUpper(runes[i:j]) {
+				variations *= 2
+			}
+			rank, ok := lookup(lower[i:j], false)
+			if !ok {
+				if rank, ok = lookup(unleet[i:j], false); ok {
+					variations *= 2
+				}
+			}
+			if !ok {
+				if rank, ok = lookup(lower[i:j], true); ok {
+					variations *= 2
+				}
+			}
+			if ok {
+				matches = append(matches, passwordMatch{
+					start: i, end: j,
+					guesses: float64(rank) * variations,
+					warning: "it contains a common password",
+				})
+			}
+		}
+	}
+	return matches
+}
+
+func keyboardMatches(lower []rune) []passwordMatch {
+	var matches []passwordMatch
+	for _, row := range keyboardRows {
+		forward := []rune(row)
+		backward := slices.Clone(forward)
+		slices.Reverse(backward)
+		for _, walk := range [][]rune{forward, backward} {
+			for i := range lower {
+				k := slices.Index(walk, lower[i])
+				if k < 0 {
+					continue
+				}
+				j := i + 1
+				for j < len(lower) && k+j-i < len(walk) && walk[k+j-i] == lower[j] {
+					j++
+				}
+				if j-i >= 4 {
+					matches = append(matches, passwordMatch{
+						start: i, end: j,
+						guesses: float64(len(keyboardRows)*2*len(walk)) * float64(j-i),
+						warning: "keyboard patterns like qwerty are easy to guess",
+					})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+func sequenceMatches(runes []rune) []passwordMatch {
+	var matches []passwordMatch
+	for i := 0; i < len(runes)-2; {
+		delta := runes[i+1] - runes[i]
+		j := i + 1
+		for j < len(runes) && runes[j]-runes[j-1] == delta && (delta == 1 || delta == -1) {
+			j++
+		}
+		if j-i >= 3 {
+			base := 26.0
+			if unicode.IsDigit(runes[i]) {
+				base = 10
+			}
+			if strings.ContainsRune("aA1z9", runes[i]) {
+				base = 4
+			}
+			if delta < 0 {
+				base *= 2
+			}
+			matches = append(matches, passwordMatch{
+				start: i, end: j,
+				guesses: base * float64(j-i),
+				warning: "sequences like abc or 123 are easy to guess",
+			})
+			i = j - 1
+			continue
+		}
+		i++
+	}
+	return matches
+}
+
+func repeatMatches(runes []rune) []passwordMatch {
+	var matches []passwordMatch
+	for i := 0; i < len(runes); {
+		j := i + 1
+		for j < len(runes) && runes[j] == runes[i] {
+			j++
+		}
+		if j-i >= 3 {
+			matches = append(matches, passwordMatch{
+				start: i, end: j,
+				guesses: 26 * float64(j-i),
+				warning: "repeated characters like aaa are easy to guess",
+			})
+		}
+		i = j
+	}
+
+	// Repeated chunks such as abcabc cost about as much as the chunk.
+	for size := 2; size <= len(runes)/2; size++ {
+		for i := 0; i+2*size <= len(runes); i++ {
+			j := i + size
+			for j+size <= len(runes) && slices.Equal(runes[j:j+size], runes[i:i+size]) {
+				j += size
+			}
+			if j-i >= 2*size {
+				matches = append(matches, passwordMatch{
+					start: i, end: j,
+					guesses: math.Pow(10, float64(size)) * float64((j-i)/size),
+					warning: "repeated words like abcabc are easy to guess",
+				})
+			}
+		}
+	}
+	return matches
+}
+
+func yearMatches(runes []rune) []passwordMatch {
+	var matches []passwordMatch
+	for i := 0; i+4 <= len(runes); i++ {
+		year, ok := digitsValue(runes[i : i+4])
+		if !ok {
+			continue
+		}
+		if year >= 1900 && year <= 2099 {
+			matches = append(matches, passwordMatch{
+				start: i, end: i + 4,
+				guesses: 200,
+				warning: "years are easy to guess",
+			})
+		}
+	}
+	return matches
+}
+
+func hasUpper(runes []rune) bool {
+	for _, r := range runes {
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeRunes decodes at most limit runes of b without converting it to a
+// string. Matching is quadratic, and anything longer scores at the top anyway.
+func decodeRunes(b []byte, limit int) []rune {
+	runes := make([]rune, 0, min(utf8.RuneCount(b), limit))
+	for len(b) > 0 && len(runes) < limit {
+		r, size := utf8.DecodeRune(b)
+		runes = append(runes, r)
+		b = b[size:]
+	}
+	return runes
+}
+
+// digitsValue returns the number written by runes if they are all ASCII
+// digits.
+func digitsValue(runes []rune) (int, bool) {
+	n := 0
+	for _, r := range runes {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+		n = n*10 + int(r-'0')
+	}
+	return n, true
+}
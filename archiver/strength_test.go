@@ -0,0 +1,31 @@
+package archiver
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEstimateStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		score    int
+	}{
+		{"", 0},
+		{"P@ssw0rd", 0},
+		{"drowssap", 0},
+		{"abcdef123", 0},
+		{"summer1987", 1},
+		{"zxcvbnm,./", 1},
+		{"correct horse battery staple", 4},
+		{"x7$Lq!v9#Rt2", 4},
+	}
+	for _, tt := range tests {
+		password := []byte(tt.password)
+		if got := EstimateStrength(password); got.Score != tt.score {
+			t.Errorf("EstimateStrength(%q) = %v (score %d), want score %d", tt.password, got, got.Score, tt.score)
+		}
+		if !bytes.Equal(password, []byte(tt.password)) {
+			t.Errorf("EstimateStrength(%q) modified the password", tt.password)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package archiver
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+const keySize = 32
+
+// ArchiveKeys are the subkeys of one archive. They are derived with HKDF from
+// the password-based master key and the random archive ID, so no two archives
+// share keys and every entry is encrypted under its own key.
+type ArchiveKeys struct {
+	prk   []byte
+	Index []byte
+	MAC   []byte
+	// Sync keys the per-entry sync markers, so they look random to anyone
+	// without the password.
+	Sync []byte
+}
+
+func DeriveArchiveKeys(masterKey *Key, h *Header) (*ArchiveKeys, error) {
+	prk, err := hkdf.Extract(sha256.New, masterKey.Bytes(), h.ArchiveID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	k := &ArchiveKeys{prk: prk}
+	if k.Index, err = hkdf.Expand(sha256.New, prk, "seaf index", keySize); err != nil {
+		k.Wipe()
+		return nil, err
+	}
+	if k.MAC, err = hkdf.Expand(sha256.New, prk, "seaf mac", keySize); err != nil {
+		k.Wipe()
+		return nil, err
+	}
+	if k.Sync, err = hkdf.Expand(sha256.New, prk, "seaf sync", keySize); err != nil {
+		k.Wipe()
+		return nil, err
+	}
+	return k, nil
+}
+
+// Wipe overwrites all subkeys. Entry keys are wiped by their users.
+func (k *ArchiveKeys) Wipe() {
+	if k == nil {
+		return
+	}
+	wipe(k.prk)
+	wipe(k.Index)
+	wipe(k.MAC)
+	wipe(k.Sync)
+}
+
+// EntryKey returns the data key for the entry at the given position. The
+// caller wipes it after use.
+func (k *ArchiveKeys) EntryKey(index uint32) ([]byte, error) {
+	var info [4]byte
+	binary.BigEndian.PutUint32(info[:], index)
+	return hkdf.Expand(sha256.New, k.prk, "seaf data "+string(info[:]), keySize)
+}
+
+// hiddenSlotKey returns the key that seals the hidden slot. It is derived
+// from the outer header, so it is the same for any credentials tried on it.
+func (k *ArchiveKeys) hiddenSlotKey() ([]byte, error) {
+	return hkdf.Expand(sha256.New, k.prk, "seaf hidden slot", keySize)
+}
+
+// syncMarker returns the marker that precedes the entry at the given
+// position, and the mask that hides the length of its local header.
+func (k *ArchiveKeys) syncMarker(index uint32) (marker [syncMarkerSize]byte, mask uint32) {
+	var info [4]byte
+	binary.BigEndian.PutUint32(info[:], index)
+	mac := hmac.New(sha256.New, k.Sync)
+	mac.Write(info[:])
+	sum := mac.Sum(nil)
+	copy(marker[:], sum)
+	return marker, binary.BigEndian.Uint32(sum[syncMarkerSize:])
+}
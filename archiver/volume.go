@@ -0,0 +1,436 @@
+package archiver
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"regexp"
+)
+
+// A split archive is stored as name.seaf.001, name.seaf.002, ... Each volume
+// starts with a plaintext volume header and carries the next slice of the
+// archive; joined together, the slices are exactly a single-file archive.
+//
+//	VolumeMagic u32 | version u8 | set ID [16] | number u32 | count u32 |
+//	volume size u64 | crc32c u32
+//
+// The random set ID ties the volumes of one archive together. Every volume
+// but the last is exactly the volume size.
+const (
+	VolumeMagic      = 0x53454156 // "SEAV"
+	volumeVersion    = 1
+	volumeHeaderSize = 41
+
+	// MinVolumeSize keeps the volume headers a small share of the archive.
+	MinVolumeSize = 64 * 1024
+)
+
+var volumeSuffix = regexp.MustCompile(`\.(\d{3,})$`)
+
+// VolumePath returns the file name of the given volume, counting from 1.
+func VolumePath(archiveFile string, number int) string {
+	return fmt.Sprintf("%s.%03d", archiveFile, number)
+}
+
+type volumeHeader struct {
+	setID  [16]byte
+	number uint32
+	count  uint32
+	size   uint64
+}
+
+func (v *volumeHeader) bytes() []byte {
+	buf := make([]byte, volumeHeaderSize)
+	binary.BigEndian.PutUint32(buf[0:4], VolumeMagic)
+	buf[4] = volumeVersion
+	copy(buf[5:21], v.setID[:])
+	binary.BigEndian.PutUint32(buf[21:25], v.number)
+	binary.BigEndian.PutUint32(buf[25:29], v.count)
+	binary.BigEndian.PutUint64(buf[29:37], v.size)
+	binary.BigEndian.PutUint32(buf[37:41], crc32.Checksum(buf[:37], crcTable))
+	return buf
+}
+
+func readVolumeHeader(r io.ReaderAt, name string) (*volumeHeader, error) {
+	buf := make([]byte, volumeHeaderSize)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return nil, errorf(ErrNotArchive, "%s is not an archive volume", name)
+	}
+	if binary.BigEndian.Uint32(buf[0:4]) != VolumeMagic {
+		return nil, errorf(ErrNotArchive, "%s is not an archive volume", name)
+	}
+	if binary.BigEndian.Uint32(buf[37:41]) != crc32.Checksum(buf[:37], crcTable) {
+		return nil, errorf(ErrCorrupt, "volume header of %s is damaged", name)
+	}
+	if buf[4] != volumeVersion {
+		return nil, errorf(ErrUnsupportedVersion, "%s: unsupported volume version %d", name, buf[4])
+	}
+	v := &volumeHeader{
+		number: binary.BigEndian.Uint32(buf[21:25]),
+		count:  binary.BigEndian.Uint32(buf[25:29]),
+		size:   binary.BigEndian.Uint64(buf[29:37]),
+	}
+	copy(v.setID[:], buf[5:21])
+	if v.size < MinVolumeSize || v.number == 0 || v.number > v.count {
+		return nil, errorf(ErrCorrupt, "volume header of %s is damaged", name)
+	}
+	return v, nil
+}
+
+// randomAccessFile is what an archive is written to and read from: a plain
+// file or a set of volumes.
+type randomAccessFile interface {
+	io.ReadWriteSeeker
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+	Sync() error
+}
+
+// volumeSet presents the volumes of a split archive as one file.
+type volumeSet struct {
+	base    string
+	header  volumeHeader
+	files   []*os.File // nil for a volume that is missing
+	sizes   []int64    // payload bytes in each volume
+	pos     int64
+	writing bool
+	lenient bool
+	closed  bool
+}
+
+func (s *volumeSet) payload() int64 {
+	return int64(s.header.size) - volumeHeaderSize
+}
+
+func (s *volumeSet) size() int64 {
+	var n int64
+	for _, size := range s.sizes {
+		n += size
+	}
+	return n
+}
+
+// createVolumes starts a new set of volumes of at most volumeSize bytes each.
+func createVolumes(archiveFile string, volumeSize uint64) (*volumeSet, error) {
+	if volumeSize < MinVolumeSize {
+		return nil, fmt.Errorf("volume size must be at least %d bytes", MinVolumeSize)
+	}
+	s := &volumeSet{base: archiveFile, writing: true}
+	s.header.size = volumeSize
+	if _, err := rand.Read(s.header.setID[:]); err != nil {
+		return nil, err
+	}
+	if err := s.addVolume(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *volumeSet) addVolume() error {
+	f, err := os.OpenFile(VolumePath(s.base, len(s.files)+1), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, f)
+	s.sizes = append(s.sizes, 0)
+	// The count is filled in by Close.
+	h := s.header
+	h.number = uint32(len(s.files))
+	h.count = h.number
+	if _, err := f.Write(h.bytes()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// openVolumes opens the volume set that archiveFile belongs to. archiveFile
+// may name any of its volumes or the archive without a volume suffix. With
+// lenient set, missing volumes read as zeros instead of failing, so salvage
+// can still reach the rest.
+func openVolumes(archiveFile string, flag int, lenient bool) (*volumeSet, error) {
+	base := archiveFile
+	if volumeSuffix.MatchString(archiveFile) {
+		base = volumeSuffix.ReplaceAllString(archiveFile, "")
+	}
+
+	first, err := os.OpenFile(VolumePath(base, 1), flag, 0)
+	if err != nil {
+		return nil, fmt.Errorf("first volume is missing: %w", err)
+	}
+	h, err := readVolumeHeader(first, VolumePath(base, 1))
+	if err != nil {
+		first.Close()
+		return nil, err
+	}
+	if h.number != 1 {
+		first.Close()
+		return nil, errorf(ErrCorrupt, "%s is volume %d of the set, not volume 1", VolumePath(base, 1), h.number)
+	}
+
+	s := &volumeSet{base: base, header: *h, lenient: lenient}
+	s.files = append(s.files, first)
+	for n := 2; n <= int(h.count); n++ {
+		name := VolumePath(base, n)
+		f, err := os.OpenFile(name, flag, 0)
+		if err == nil {
+			err = s.checkVolume(f, name, n)
+			if err != nil {
+				f.Close()
+				s.Close()
+				return nil, err
+			}
+		} else if !lenient || !os.IsNotExist(err) {
+			s.Close()
+			return nil, fmt.Errorf("volume %d of %d is missing: %w", n, h.count, err)
+		}
+		s.files = append(s.files, f)
+	}
+
+	// Every volume but the last is full. A lenient open takes that for
+	// granted and reads whatever is missing as zeros.
+	for n, f := range s.files {
+		last := n == len(s.files)-1
+		size := s.payload()
+		if f != nil && (last || !lenient) {
+			info, err := f.Stat()
+			if err != nil {
+				s.Close()
+				return nil, err
+			}
+			size = info.Size() - volumeHeaderSize
+		} else if f == nil && last {
+			size = 0
+		}
+		if size > s.payload() || (!last && size != s.payload()) {
+			s.Close()
+			return nil, errorf(ErrCorrupt, "volume %d of %d has the wrong size", n+1, h.count)
+		}
+		s.sizes = append(s.sizes, size)
+	}
+	return s, nil
+}
+
+func (s *volumeSet) checkVolume(f *os.File, name string, n int) error {
+	h, err := readVolumeHeader(f, name)
+	if err != nil {
+		return err
+	}
+	if h.setID != s.header.setID || h.count != s.header.count || h.size != s.header.size {
+		return errorf(ErrCorrupt, "%s belongs to a different archive", name)
+	}
+	if int(h.number) != n {
+		return errorf(ErrCorrupt, "volumes are out of order: %s is volume %d, not volume %d", name, h.number, n)
+	}
+	return nil
+}
+
+// missingVolumes returns the names of the volumes a lenient open skipped.
+func (s *volumeSet) missingVolumes() []string {
+	var names []string
+	for n, f := range s.files {
+		if f == nil {
+			names = append(names, VolumePath(s.base, n+1))
+		}
+	}
+	return names
+}
+
+func (s *volumeSet) Read(p []byte) (int, error) {
+	n, err := s.ReadAt(p, s.pos)
+	s.pos += int64(n)
+	return n, err
+}
+
+func (s *volumeSet) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	size := s.size()
+	var read int
+	for len(p) > 0 && off < size {
+		v, voff := off/s.payload(), off%s.payload()
+		chunk := p[:min(int64(len(p)), s.sizes[v]-voff)]
+		if s.files[v] == nil {
+			clear(chunk)
+		} else if n, err := s.files[v].ReadAt(chunk, volumeHeaderSize+voff); err != nil {
+			if err != io.EOF || !s.lenient {
+				return read + n, err
+			}
+			clear(chunk[n:])
+		}
+		read += len(chunk)
+		p = p[len(chunk):]
+		off += int64(len(chunk))
+	}
+	if len(p) > 0 {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+func (s *volumeSet) Write(p []byte) (int, error) {
+	n, err := s.WriteAt(p, s.pos)
+	s.pos += int64(n)
+	return n, err
+}
+
+// WriteAt writes across volumes. Only a set being created grows; writes
+// past its last volume start new ones.
+func (s *volumeSet) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	var written int
+	for len(p) > 0 {
+		v, voff := off/s.payload(), off%s.payload()
+		for int(v) >= len(s.files) {
+			if !s.writing {
+				return written, errors.New("write past the end of the last volume")
+			}
+			if err := s.addVolume(); err != nil {
+				return written, err
+			}
+		}
+		if s.files[v] == nil {
+			return written, fmt.Errorf("%s is missing", VolumePath(s.base, int(v)+1))
+		}
+		chunk := p[:min(int64(len(p)), s.payload()-voff)]
+		if _, err := s.files[v].WriteAt(chunk, volumeHeaderSize+voff); err != nil {
+			return written, err
+		}
+		s.sizes[v] = max(s.sizes[v], voff+int64(len(chunk)))
+		written += len(chunk)
+		p = p[len(chunk):]
+		off += int64(len(chunk))
+	}
+	return written, nil
+}
+
+func (s *volumeSet) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+// Sync flushes every volume to disk.
+func (s *volumeSet) Sync() error {
+	for _, f := range s.files {
+		if f == nil {
+			continue
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every volume. For a new set it first records the final
+// volume count in each volume header.
+func (s *volumeSet) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	var firstErr error
+	for n, f := range s.files {
+		if f == nil {
+			continue
+		}
+		if s.writing {
+			h := s.header
+			h.number = uint32(n + 1)
+			h.count = uint32(len(s.files))
+			if _, err := f.WriteAt(h.bytes(), 0); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// renameVolumes moves the count volumes written under the name from to
+// archiveFile, and deletes the volumes left over from an earlier, longer
+// archive of that name, so they are not mistaken for part of this one.
+// Without overwrite it fails if any volume exists, and removes the ones it
+// already moved.
+func renameVolumes(from, archiveFile string, count int, overwrite bool) error {
+	for n := 1; n <= count; n++ {
+		if err := publish(VolumePath(from, n), VolumePath(archiveFile, n), overwrite); err != nil {
+			if !overwrite {
+				for m := 1; m < n; m++ {
+					os.Rename(VolumePath(archiveFile, m), VolumePath(from, m))
+				}
+			}
+			return err
+		}
+	}
+	for n := count + 1; ; n++ {
+		name := VolumePath(archiveFile, n)
+		f, err := os.Open(name)
+		if err != nil {
+			return nil
+		}
+		_, err = readVolumeHeader(f, name)
+		f.Close()
+		if err != nil || os.Remove(name) != nil {
+			return nil
+		}
+	}
+}
+
+// removeVolumes deletes the first count volumes of archiveFile.
+func removeVolumes(archiveFile string, count int) {
+	for n := 1; n <= count; n++ {
+		os.Remove(VolumePath(archiveFile, n))
+	}
+}
+
+// isVolumeSet reports whether archiveFile is, or names, a split archive:
+// either a file starting with a volume header, or a missing file whose
+// first volume exists.
+func isVolumeSet(archiveFile string) bool {
+	f, err := os.Open(archiveFile)
+	if os.IsNotExist(err) {
+		_, err := os.Stat(VolumePath(archiveFile, 1))
+		return err == nil
+	}
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var magic [4]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return false
+	}
+	return binary.BigEndian.Uint32(magic[:]) == VolumeMagic
+}
+
+// openArchiveFile opens a single-file archive or a set of volumes with the
+// given os.OpenFile flag.
+func openArchiveFile(archiveFile string, flag int) (randomAccessFile, error) {
+	if isVolumeSet(archiveFile) {
+		return openVolumes(archiveFile, flag, false)
+	}
+	return os.OpenFile(archiveFile, flag, 0)
+}
@@ -0,0 +1,95 @@
+package archiver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVolumeSetSplitAndJoin(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "test.seaf")
+	s, err := createVolumes(base, MinVolumeSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := s.payload()
+	data := randomBytes(t, int(3*payload+123))
+
+	// Uneven writes, so chunks straddle the volume boundaries.
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 10007)
+		if _, err := s.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for n := 1; n <= 4; n++ {
+		info, err := os.Stat(VolumePath(base, n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n < 4 && info.Size() != MinVolumeSize {
+			t.Errorf("volume %d has %d bytes, want %d", n, info.Size(), MinVolumeSize)
+		}
+	}
+	if _, err := os.Stat(VolumePath(base, 5)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("volume 5 exists: %v", err)
+	}
+
+	// Any volume names the set.
+	r, err := openVolumes(VolumePath(base, 3), os.O_RDONLY, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("joined volumes differ from the data written")
+	}
+	for _, off := range []int64{0, payload - 1, payload, 2*payload - 5, 3 * payload} {
+		buf := make([]byte, 10)
+		n, err := r.ReadAt(buf, off)
+		if err != nil {
+			t.Fatalf("ReadAt(%d): %v", off, err)
+		}
+		if !bytes.Equal(buf[:n], data[off:off+int64(n)]) {
+			t.Errorf("ReadAt(%d) differs from the data written", off)
+		}
+	}
+}
+
+func TestVolumeArchiveRoundTrip(t *testing.T) {
+	data := randomBytes(t, 300*1024)
+	archive := createTestArchive(t, data, ArchiveOptions{VolumeSize: MinVolumeSize})
+	if _, err := os.Stat(archive); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("split archive also written as a single file: %v", err)
+	}
+	if _, err := os.Stat(VolumePath(archive, 5)); err != nil {
+		t.Fatalf("expected at least 5 volumes: %v", err)
+	}
+
+	checkExtract(t, archive, data)
+	checkExtract(t, VolumePath(archive, 2), data)
+}
+
+func TestVolumeArchiveMissingVolume(t *testing.T) {
+	archive := createTestArchive(t, randomBytes(t, 200*1024), ArchiveOptions{VolumeSize: MinVolumeSize})
+	if err := os.Remove(VolumePath(archive, 2)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ExtractArchive(testCredentials(), testSalt, archive, t.TempDir())
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("ExtractArchive = %v, want a missing volume error", err)
+	}
+}
@@ -1,17 +1,25 @@
 package main
 
 import (
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/hex"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"seaf/archiver"
 	"seaf/ui"
+
+	"golang.org/x/term"
 )
 
 var (
@@ -25,19 +33,248 @@ var (
 	compressLevel  int
 	optimizeImages bool
 	imageQuality   float64
+	plainIndex     bool
+	keyFiles       stringList
+	cipherName     string
+	signKeyFile    string
+	signerKey      string
+	hiddenFiles    stringList
+	hiddenPassword string
+	hiddenKeyFiles stringList
+	reserveSize    string
+	padding        string
+
+	kdfTarget         string
+	recoverySize      string
+	volumeSize        string
+	minPasswordScore  string
+	allowWeakPassword bool
+	force             bool
+	makeParents       bool
 )
 
+// stringList is a flag that can be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// command is a subcommand of the CLI.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+// commandList returns the subcommands in the order help lists them.
+func commandList() []command {
+	return []command{
+		{"create", "Create an archive", runCreate},
+		{"extract", "Extract an archive", runExtract},
+		{"cat", "Write one entry to stdout", runCat},
+		{"list", "List the entries of an archive", runList},
+		{"test", "Check every entry without extracting", runTest},
+		{"info", "Show the public header fields, no password needed", runInfo},
+		{"verify", "Check the signature of an archive", runVerify},
+		{"repair", "Fix damage from the recovery record", runRepair},
+		{"salvage", "Recover the intact entries of a damaged archive", runSalvage},
+		{"genpass", "Generate a diceware passphrase", runGenpass},
+		{"bench-kdf", "Time the key derivation at different costs", runBenchKDF},
+		{"gui", "Launch the graphical interface", runGUI},
+		{"help", "Show help for a command", runHelp},
+	}
+}
+
+// version is recorded in the archives this build creates. Release builds
+// set it with -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
 func main() {
+	archiver.DefaultCreator = "seaf " + version
+
+	// Without arguments, e.g. when started from a file manager, open the GUI.
 	if len(os.Args) == 1 {
-		runGUI()
-	} else {
-		runTUI()
+		runGUI(nil)
+		return
+	}
+
+	name := os.Args[1]
+	if strings.HasPrefix(name, "-") && name != "-h" && name != "-help" && name != "--help" {
+		runLegacy(os.Args[1:])
+		return
+	}
+	for _, cmd := range commandList() {
+		if cmd.name == name {
+			cmd.run(os.Args[2:])
+			return
+		}
+	}
+	if name != "-h" && name != "-help" && name != "--help" {
+		fmt.Fprintf(os.Stderr, "Unknown command %q; run 'seaf help' for the list of commands\n", name)
+		os.Exit(exitUsage)
 	}
+	printUsage(os.Stdout)
 }
 
-func runTUI() {
-	flag.Parse()
+func runHelp(args []string) {
+	if len(args) == 1 {
+		for _, cmd := range commandList() {
+			if cmd.name == args[0] && cmd.name != "help" {
+				cmd.run([]string{"-h"})
+				return
+			}
+		}
+	}
+	printUsage(os.Stdout)
+}
+
+// printUsage prints the list of commands.
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, asciiArt)
+	fmt.Fprintln(w, "Secure Archiver is a tool for encrypting and archiving files.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Usage: seaf <command> [options] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commandList() {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'seaf help <command>' or 'seaf <command> -h' for its options.")
+	fmt.Fprintln(w, "Without arguments seaf launches the GUI. Without a password option the")
+	fmt.Fprintln(w, "password is prompted for.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "The old form without a command, e.g. 'seaf --salt=... file1' and")
+	fmt.Fprintln(w, "'seaf --salt=... --extract --archive=x.seaf', still works but is deprecated.")
+}
+
+// runLegacy handles the deprecated form that selects creating or extracting
+// with global flags.
+func runLegacy(args []string) {
+	parseFlags(flag.CommandLine, args)
+	fmt.Fprintln(os.Stderr, "Warning: running without a command is deprecated; use 'seaf create' or 'seaf extract' (see 'seaf help')")
+
+	if !extract && outputFile == "-" {
+		reserveStdout(false)
+	}
+	prepareSalt(flag.Usage)
+	if extract {
+		if flag.NArg() != 0 {
+			fmt.Fprintln(os.Stderr, "Unexpected arguments after --extract:", strings.Join(flag.Args(), " "))
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		creds := readCredentials(false)
+		defer creds.Wipe()
+		if _, err := extractArchive(creds, archiveFile, filepath.Dir(archiveFile)); err != nil {
+			fatal(err)
+		}
+		return
+	}
 
+	if flag.NArg() == 0 {
+		fmt.Println("No input files are specified.")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	creds := readCredentials(true)
+	defer creds.Wipe()
+	createArchive(creds, flag.Args())
+}
+
+func runCreate(args []string) {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	addCredentialFlags(fs)
+	addCreateFlags(fs)
+	addFormatFlags(fs)
+	fs.Usage = func() {
+		fmt.Println("Usage: seaf create --salt=<hex> [options] file...")
+		fmt.Println()
+		fmt.Println("Encrypts the files into a new archive. It is written to a temporary")
+		fmt.Println("file next to --output and renamed when complete.")
+		fmt.Println()
+		fs.PrintDefaults()
+		fmt.Println()
+		fmt.Println("Examples:")
+		fmt.Println("  seaf create --salt=... --output=archive.seaf file1 file2")
+		fmt.Println("  seaf create --salt=... --parents --force --output=/backups/today.seaf file1")
+		fmt.Println("  seaf create --generate-salt --keyfile=secret.key --no-password --output=archive.seaf file1")
+		fmt.Println("  seaf create --salt=... --sign-key=sign.key --recovery=5% --output=archive.seaf file1")
+		fmt.Println("  seaf create --salt=... --reserve=10M --hidden=secret.txt --output=archive.seaf decoy.txt")
+		fmt.Println("  seaf create --salt=... --volume-size=2G --kdf-target=1s --output=archive.seaf bigfile")
+		fmt.Println("  seaf create --salt=... --password-file=pw.txt -o - file1 | ssh host 'cat > x.seaf'")
+	}
+	fs.StringVar(&outputFile, "o", "archive.seaf", "Short for --output; - writes the archive to stdout")
+	parseFlags(fs, args)
+
+	if fs.NArg() == 0 {
+		fmt.Println("No input files are specified.")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if outputFile == "-" {
+		reserveStdout(false)
+	}
+	startReport("create")
+	prepareSalt(fs.Usage)
+	creds := readCredentials(true)
+	defer creds.Wipe()
+	createArchive(creds, fs.Args())
+}
+
+func runExtract(args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	addCredentialFlags(fs)
+	fs.StringVar(&signerKey, "signer", "", "Ed25519 public key (PEM file or hex) the archive must be signed by")
+	outputDir := fs.String("o", "", "Directory to extract into (default: the archive's directory)")
+	addFormatFlags(fs)
+	fs.Usage = func() {
+		fmt.Println("Usage: seaf extract --salt=<hex> [options] archive.seaf|-")
+		fmt.Println()
+		fmt.Println("Decrypts every entry of the archive, or of a hidden archive if the")
+		fmt.Println("password opens one. A split archive is given by any of its volumes,")
+		fmt.Println("and - reads the archive from stdin.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if saltHex == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *outputDir == "" {
+		*outputDir = filepath.Dir(fs.Arg(0))
+	}
+	startReport("extract")
+	creds := readCredentials(false)
+	defer creds.Wipe()
+	path, cleanup := archivePath(fs.Arg(0))
+	index, err := extractArchive(creds, path, *outputDir)
+	cleanup()
+	if err != nil {
+		fatal(err)
+	}
+
+	result := extractResult{Archive: fs.Arg(0), OutputDir: *outputDir, Files: []extractFile{}}
+	for _, entry := range index.Entries {
+		result.Files = append(result.Files, extractFile{
+			Name: entry.Name,
+			Path: filepath.Join(*outputDir, entry.Name),
+			Size: entry.OriginalSize,
+		})
+	}
+	finishReport(true, result)
+}
+
+// prepareSalt generates the salt if asked to and exits if there is none.
+func prepareSalt(usage func()) {
 	if generateSalt {
 		var err error
 		saltHex, err = generateRandomSalt(saltLength)
@@ -47,163 +284,831 @@ func runTUI() {
 		}
 		fmt.Printf("Generated salt (hex): %s\n", saltHex)
 	}
-	if password == "" || saltHex == "" {
-		fmt.Println("You must specify the password and the salt.")
-		flag.Usage()
-		os.Exit(1)
+	if saltHex == "" {
+		fmt.Println("You must specify the salt.")
+		usage()
+		os.Exit(exitUsage)
 	}
+}
 
-	if extract {
-		archiveDir := filepath.Dir(archiveFile)
-		err := archiver.ExtractArchive(password, saltHex, archiveFile, archiveDir)
-		if err != nil {
-			log.Fatalf("Error extracting the archive: %v", err)
+// readCredentials reads the password as selected on the command line. New
+// archives have their password confirmed and checked against the policy.
+func readCredentials(create bool) archiver.Credentials {
+	pw, err := readPassword(create, len(keyFiles) > 0)
+	if err != nil {
+		fatalf("Error reading password: %v", err)
+	}
+	creds := archiver.Credentials{Password: pw, KeyFiles: keyFiles}
+	if create {
+		checkPasswordPolicy(creds, "")
+	}
+	return creds
+}
+
+func extractArchive(creds archiver.Credentials, archivePath, outputDir string) (*archiver.Index, error) {
+	if signerKey != "" {
+		if err := checkSigner(archivePath, signerKey); err != nil {
+			return nil, err
 		}
+	}
 
-		fmt.Println("The extraction was completed successfully!")
-	} else {
-		inputFiles := flag.Args()
-		if len(inputFiles) == 0 {
-			fmt.Println("No input files are specified.")
-			flag.Usage()
-			os.Exit(1)
+	index, err := archiver.ExtractArchive(creds, saltHex, archivePath, outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error extracting the archive: %w", err)
+	}
+
+	infof("The extraction was completed successfully!\n")
+	return index, nil
+}
+
+// archivePath returns path, or for "-" a temporary copy of stdin, since
+// archives are read starting from the end. cleanup removes the copy.
+func archivePath(path string) (string, func()) {
+	if path != "-" {
+		return path, func() {}
+	}
+	if passwordStdin {
+		fatal("--password-stdin can't be used while the archive is read from stdin")
+	}
+
+	f, err := os.CreateTemp("", "seaf-stdin-*.seaf")
+	if err != nil {
+		fatalf("Error buffering stdin: %v", err)
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	_, err = io.Copy(f, os.Stdin)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		fatalf("Error buffering stdin: %v", err)
+	}
+	return f.Name(), cleanup
+}
+
+// stdout receives archives and entries written to "-". os.Stdout is then
+// pointed at stderr, so that messages don't end up in the data.
+var stdout = os.Stdout
+
+// reserveStdout keeps stdout for data. Archives are never written to a
+// terminal; entries may be, since they are often text.
+func reserveStdout(allowTerminal bool) {
+	if !allowTerminal && term.IsTerminal(int(stdout.Fd())) {
+		fatal("Refusing to write binary data to a terminal; redirect stdout")
+	}
+	os.Stdout = os.Stderr
+}
+
+func createArchive(creds archiver.Credentials, inputFiles []string) {
+	cipherSuite, err := archiver.ParseCipherSuite(cipherName)
+	if err != nil {
+		fatal(err)
+	}
+
+	paddingPolicy, err := archiver.ParsePaddingPolicy(padding)
+	if err != nil {
+		fatal(err)
+	}
+
+	files, err := archiver.CollectFiles(inputFiles)
+	if err != nil {
+		fatalf("Error when collecting files: %v", err)
+	}
+
+	volumes, err := parseSize(volumeSize)
+	if err != nil {
+		fatalf("Invalid --volume-size: %v", err)
+	}
+	if volumes > 0 && volumes < archiver.MinVolumeSize {
+		fatalf("Invalid --volume-size: must be at least %s", formatBytes(archiver.MinVolumeSize))
+	}
+
+	toStdout := outputFile == "-"
+	fullOutputPath := "stdout"
+	result := createResult{Archive: outputFile}
+	if generateSalt {
+		result.Salt = saltHex
+	}
+	if !toStdout {
+		fullOutputPath = outputFile
+		if err := prepareOutput(fullOutputPath, volumes > 0); err != nil {
+			fatal(err)
+		}
+	}
+
+	kdfParams := archiver.DefaultKDFParams
+	if kdfTarget != "" {
+		target, err := time.ParseDuration(kdfTarget)
+		if err != nil || target <= 0 {
+			fatalf("Invalid --kdf-target %q: use a duration such as 1s or 500ms", kdfTarget)
+		}
+		if kdfParams, err = archiver.CalibrateKDF(target, archiver.DefaultKDFMaxMemory); err != nil {
+			fatalf("Error calibrating the key derivation: %v", err)
 		}
+		infof("Key derivation: %s (%s of memory)\n", kdfParams, formatBytes(kdfParams.Memory()))
+	}
 
-		files, err := archiver.CollectFiles(inputFiles)
+	var signingKey ed25519.PrivateKey
+	if signKeyFile != "" {
+		signingKey, err = archiver.LoadSigningKey(signKeyFile)
 		if err != nil {
-			log.Fatalf("Error when collecting files: %v", err)
+			fatalf("Error loading signing key: %v", err)
 		}
+	}
+
+	reserve, err := parseSize(reserveSize)
+	if err != nil {
+		fatalf("Invalid --reserve: %v", err)
+	}
 
-		totalOriginalSize := int64(0)
-		totalCompressedSize := int64(0)
-		totalEncryptedSize := int64(0)
+	recovery, err := archiver.ParseRecoveryPercent(recoverySize)
+	if err != nil {
+		fatalf("Invalid --recovery: %v", err)
+	}
 
-		salt, err := hex.DecodeString(saltHex)
+	var hidden *archiver.HiddenArchive
+	if len(hiddenFiles) > 0 {
+		if reserve == 0 {
+			fatal("--hidden needs --reserve, large enough to hold the hidden files; use the same --reserve for the decoy archives")
+		}
+		hiddenList, err := archiver.CollectFiles(hiddenFiles)
 		if err != nil {
-			log.Fatalf("Error decoding salt: %v", err)
+			fatalf("Error when collecting hidden files: %v", err)
 		}
-
-		key, err := archiver.GenerateKey(password, salt)
+		hiddenPw, err := readHiddenPassword(len(hiddenKeyFiles) > 0)
 		if err != nil {
-			log.Fatalf("Error generating key: %v", err)
+			fatalf("Error reading hidden archive password: %v", err)
+		}
+		hidden = &archiver.HiddenArchive{
+			Credentials: archiver.Credentials{Password: hiddenPw, KeyFiles: hiddenKeyFiles},
+			Files:       hiddenList,
 		}
+		checkPasswordPolicy(hidden.Credentials, "hidden archive ")
+		defer hidden.Credentials.Wipe()
+	}
 
-		for _, file := range files {
-			data, err := os.ReadFile(file.Path)
-			if err != nil {
-				log.Fatalf("Error reading file %s: %v", file.Path, err)
-			}
+	opts := archiver.ArchiveOptions{
+		CompressLevel:  compressLevel,
+		OptimizeImages: optimizeImages,
+		ImageQuality:   float32(imageQuality),
+		PlainIndex:     plainIndex,
+		Cipher:         cipherSuite,
+		KDF:            kdfParams,
+		Padding:        paddingPolicy,
+		SigningKey:     signingKey,
+		Reserve:        reserve,
+		Hidden:         hidden,
+		Recovery:       recovery,
+		VolumeSize:     volumes,
+		Overwrite:      force,
+	}
+	var stats []archiver.EntryStats
+	if toStdout {
+		stats, err = archiver.WriteArchive(stdout, creds, saltHex, files, opts)
+	} else {
+		stats, err = archiver.CreateArchive(creds, saltHex, fullOutputPath, files, opts)
+	}
+	if err != nil {
+		fatalf("Error creating the archive: %v", err)
+	}
+	printEntryStats(stats, paddingPolicy, &result)
 
-			if optimizeImages {
-				optData, changed, err := archiver.OptimizeImage(data, file.Path, float32(imageQuality))
-				if err == nil && changed {
-					fmt.Printf("Optimized %s: %d -> %d bytes\n", filepath.Base(file.Path), len(data), len(optData))
-					data = optData
-				}
+	if volumes > 0 {
+		for n := 1; ; n++ {
+			if _, err := os.Stat(archiver.VolumePath(fullOutputPath, n)); err != nil {
+				break
 			}
+			infof("Volume written: %s\n", archiver.VolumePath(fullOutputPath, n))
+			result.Volumes = append(result.Volumes, archiver.VolumePath(fullOutputPath, n))
+		}
+	}
+	infof("Archive successfully created: %s\n", fullOutputPath)
+	infof("Archiving and encryption have been completed successfully.\n")
+	finishReport(true, result)
+}
 
-			compressedData, _, err := archiver.PrepareEntryData(data, compressLevel)
-			if err != nil {
-				log.Fatalf("Error compressing file %s: %v", file.Path, err)
-			}
+func runCat(args []string) {
+	fs := flag.NewFlagSet("cat", flag.ExitOnError)
+	addCredentialFlags(fs)
+	fs.Usage = func() {
+		fmt.Println("Usage: seaf cat --salt=<hex> [password options] archive.seaf|- name")
+		fmt.Println()
+		fmt.Println("Decrypts the entry called name and writes it to stdout, e.g. for")
+		fmt.Println("piping into jq or psql. - reads the archive from stdin.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
 
-			encryptedData, err := archiver.Encrypt(compressedData, key)
-			if err != nil {
-				log.Fatalf("Error encrypting file %s: %v", file.Path, err)
-			}
+	if saltHex == "" || fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	reserveStdout(true)
+
+	pw, err := readPassword(false, len(keyFiles) > 0)
+	if err != nil {
+		fatalf("Error reading password: %v", err)
+	}
+	creds := archiver.Credentials{Password: pw, KeyFiles: keyFiles}
+	path, cleanup := archivePath(fs.Arg(0))
+	err = archiver.ExtractEntry(creds, saltHex, path, fs.Arg(1), stdout)
+	cleanup()
+	creds.Wipe()
+	if err != nil {
+		fatalf("Error reading %s: %v", fs.Arg(1), err)
+	}
+}
 
-			fmt.Printf("\n--- Processing: %s ---\n", file.Path)
-			fmt.Printf("Original size: %d bytes (%.2f MB)\n", len(data), float64(len(data))/(1024*1024))
+func runInfo(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	addCredentialFlags(fs)
+	addFormatFlags(fs)
+	fs.Usage = func() {
+		fmt.Println("Usage: seaf info [--salt=<hex> [password options]] archive.seaf|-")
+		fmt.Println()
+		fmt.Println("Shows what the archive reveals without the password: the format")
+		fmt.Println("version, key derivation, cipher and options it was created with.")
+		fmt.Println("The entry count, sizes, compression, creation time and creator are")
+		fmt.Println("in the index, so an encrypted index needs --salt and the password.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
 
-			entropy := calculateEntropy(data)
-			fmt.Printf("Data entropy: %.4f (0-1, the higher it is, the worse it shrinks)\n", entropy)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
 
-			compressionRatio := float64(len(compressedData)) / float64(len(data)) * 100
-			fmt.Printf("After compression: %d bytes, Ratio: %.2f%%\n", len(compressedData), compressionRatio)
-			fmt.Printf("After encryption: %d bytes\n", len(encryptedData))
-			fmt.Printf("Total overhead: %+d bytes\n", len(encryptedData)-len(data))
+	startReport("info")
+	var creds archiver.Credentials
+	if saltHex != "" {
+		pw, err := readPassword(false, len(keyFiles) > 0)
+		if err != nil {
+			fatalf("Error reading password: %v", err)
+		}
+		creds = archiver.Credentials{Password: pw, KeyFiles: keyFiles}
+		defer creds.Wipe()
+	}
 
-			totalOriginalSize += int64(len(data))
-			totalCompressedSize += int64(len(compressedData))
-			totalEncryptedSize += int64(len(encryptedData))
+	path, cleanup := archivePath(fs.Arg(0))
+	defer cleanup()
+	info, err := archiver.ReadArchiveInfo(path)
+	if err != nil {
+		fatalf("Error reading the archive: %v", err)
+	}
+	contents := info.Contents
+	if saltHex != "" {
+		index, err := archiver.ListArchive(creds, saltHex, path)
+		if err != nil {
+			fatalf("Error opening the archive: %v", err)
 		}
+		contents = archiver.SummarizeIndex(index)
+	}
+	h := info.Header
+	if report != nil {
+		finishReport(true, newInfoResult(fs.Arg(0), info, contents, saltHex != ""))
+		return
+	}
+	yesNo := map[bool]string{true: "yes", false: "no"}
 
-		fmt.Printf("\n=== FINAL RESULTS ===\n")
-		fmt.Printf("Original total: %d bytes (%.2f MB)\n", totalOriginalSize, float64(totalOriginalSize)/(1024*1024))
-		fmt.Printf("Compressed total: %d bytes (%.2f MB)\n", totalCompressedSize, float64(totalCompressedSize)/(1024*1024))
-		fmt.Printf("Encrypted total: %d bytes (%.2f MB)\n", totalEncryptedSize, float64(totalEncryptedSize)/(1024*1024))
-		fmt.Printf("Final compression: %.2f%%\n", float64(totalCompressedSize)/float64(totalOriginalSize)*100)
-		fmt.Printf("Archive overhead: %.2f%%\n", float64(totalEncryptedSize-totalOriginalSize)/float64(totalOriginalSize)*100)
+	fmt.Printf("Format version:  %d\n", h.Version)
+	fmt.Printf("Key derivation:  %s (%s of memory)\n", h.KDF, formatBytes(h.KDF.Memory()))
+	fmt.Printf("Cipher:          %s\n", h.Cipher)
+	fmt.Printf("Encrypted index: %s\n", yesNo[h.EncryptedIndex()])
+	fmt.Printf("Key files:       %s\n", yesNo[h.KeyFiles()])
+	fmt.Printf("Padded:          %s\n", yesNo[h.Padded()])
+	fmt.Printf("Signed:          %s\n", yesNo[h.Signed()])
+	// Every entry is compressed on its own, so that one can be extracted
+	// or salvaged without the others.
+	fmt.Printf("Solid:           no\n")
+	if info.Volumes > 0 {
+		fmt.Printf("Split:           yes, %d volumes\n", info.Volumes)
+	} else {
+		fmt.Printf("Split:           no\n")
+	}
+	if info.RecoverySize > 0 {
+		fmt.Printf("Recovery record: %s\n", formatBytes(uint64(info.RecoverySize)))
+	} else {
+		fmt.Printf("Recovery record: no\n")
+	}
+	fmt.Printf("Size:            %s\n", formatBytes(uint64(info.Size)))
 
-		if err := createOutputDir(); err != nil {
-			log.Fatalf("Error creating output directory: %v", err)
+	if contents == nil {
+		fmt.Println("Contents:        encrypted (give --salt and the password to show them)")
+		return
+	}
+	methods := make([]string, 0, len(contents.Compression))
+	for name, n := range contents.Compression {
+		methods = append(methods, fmt.Sprintf("%s (%d)", name, n))
+	}
+	sort.Strings(methods)
+	if len(methods) == 0 {
+		methods = append(methods, "-")
+	}
+	created, creator := "unknown", "unknown"
+	if !contents.Meta.Created.IsZero() {
+		created = contents.Meta.Created.Local().Format(time.RFC3339)
+	}
+	if contents.Meta.Creator != "" {
+		creator = contents.Meta.Creator
+	}
+
+	fmt.Printf("Entries:         %d\n", contents.Entries)
+	fmt.Printf("Original size:   %s\n", formatBytes(contents.OriginalSize))
+	fmt.Printf("Stored size:     %s\n", formatBytes(contents.StoredSize))
+	fmt.Printf("Compression:     %s\n", strings.Join(methods, ", "))
+	fmt.Printf("Created:         %s\n", created)
+	fmt.Printf("Creator:         %s\n", creator)
+	if saltHex == "" {
+		fmt.Println("(contents read from the plain index without authentication)")
+	}
+}
+
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	signer := fs.String("signer", "", "Public key of the expected signer (PEM file or 64 hex digits)")
+	fs.Usage = func() {
+		fmt.Println("Usage: seaf verify --signer=<pubkey> archive.seaf")
+		fmt.Println()
+		fmt.Println("Checks the archive signature without the password.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if *signer == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	verifySigner(fs.Arg(0), *signer)
+}
+
+func runTest(args []string) {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	addCredentialFlags(fs)
+	addFormatFlags(fs)
+	fs.Usage = func() {
+		fmt.Println("Usage: seaf test --salt=<hex> [password options] archive.seaf|-")
+		fmt.Println()
+		fmt.Println("Decrypts, authenticates and decompresses every entry without writing")
+		fmt.Println("anything, reports all bad entries and exits non-zero if any check fails.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if saltHex == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	startReport("test")
+	pw, err := readPassword(false, len(keyFiles) > 0)
+	if err != nil {
+		fatalf("Error reading password: %v", err)
+	}
+	creds := archiver.Credentials{Password: pw, KeyFiles: keyFiles}
+	path, cleanup := archivePath(fs.Arg(0))
+	test, err := archiver.TestArchive(creds, saltHex, path)
+	cleanup()
+	creds.Wipe()
+	if err != nil {
+		fatalf("Error opening the archive: %v", err)
+	}
+
+	bad := make(map[int]error)
+	for _, e := range test.Bad {
+		bad[e.Index] = e.Err
+	}
+	result := testResult{Archive: fs.Arg(0), Entries: []testEntry{}, Bad: len(test.Bad)}
+	for i, entry := range test.Entries {
+		if err, ok := bad[i]; ok {
+			fmt.Printf("BAD  %s: %v\n", entry.Name, err)
+			result.Entries = append(result.Entries, testEntry{Name: entry.Name, Error: err.Error()})
+		} else {
+			infof("OK   %s\n", entry.Name)
+			result.Entries = append(result.Entries, testEntry{Name: entry.Name, OK: true})
 		}
+	}
+	if test.Err != nil {
+		fmt.Printf("Archive check failed: %v\n", test.Err)
+		result.Error = test.Err.Error()
+	}
+	infof("%d entries, %d bad\n", len(test.Entries), len(test.Bad))
+	finishReport(test.OK(), result)
+	if !test.OK() {
+		os.Exit(exitCorrupt)
+	}
+}
+
+func runList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	addCredentialFlags(fs)
+	checksums := fs.Bool("checksums", false, "Print SHA-256 checksums in sha256sum format")
+	addFormatFlags(fs)
+	fs.Usage = func() {
+		fmt.Println("Usage: seaf list --salt=<hex> [password options] [--checksums] archive.seaf|-")
+		fmt.Println()
+		fmt.Println("Lists the entries of an archive. With --checksums the output can be")
+		fmt.Println("checked against extracted files with sha256sum -c.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
 
-		fullOutputPath := filepath.Join("output", outputFile)
+	if saltHex == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
 
-		err = archiver.CreateArchive(password, saltHex, fullOutputPath, files, compressLevel, optimizeImages, float32(imageQuality))
-		if err != nil {
-			log.Fatalf("Error creating the archive: %v", err)
+	startReport("list")
+	pw, err := readPassword(false, len(keyFiles) > 0)
+	if err != nil {
+		fatalf("Error reading password: %v", err)
+	}
+	creds := archiver.Credentials{Password: pw, KeyFiles: keyFiles}
+	path, cleanup := archivePath(fs.Arg(0))
+	index, err := archiver.ListArchive(creds, saltHex, path)
+	cleanup()
+	creds.Wipe()
+	if err != nil {
+		fatalf("Error opening the archive: %v", err)
+	}
+
+	if report != nil {
+		result := listResult{Archive: fs.Arg(0), Entries: []listEntry{}}
+		for _, entry := range index.Entries {
+			e := listEntry{
+				Name:        entry.Name,
+				Size:        entry.OriginalSize,
+				StoredSize:  entry.StoredSize,
+				Compression: archiver.CompressionName(entry.CompressionMethod),
+			}
+			if entry.HasChecksum() {
+				e.SHA256 = hex.EncodeToString(entry.Checksum[:])
+			}
+			result.Entries = append(result.Entries, e)
+		}
+		finishReport(true, result)
+		return
+	}
+	for _, entry := range index.Entries {
+		if !*checksums {
+			fmt.Printf("%12d  %s\n", entry.OriginalSize, entry.Name)
+			continue
 		}
+		if !entry.HasChecksum() {
+			slog.Warn("no checksum recorded, the archive has a plain index", "entry", entry.Name)
+			continue
+		}
+		fmt.Printf("%x  %s\n", entry.Checksum, entry.Name)
+	}
+}
+
+func runRepair(args []string) {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Only report damaged blocks, don't rewrite them")
+	fs.Usage = func() {
+		fmt.Println("Usage: seaf repair [--dry-run] archive.seaf")
+		fmt.Println()
+		fmt.Println("Rebuilds damaged blocks in place from the archive's recovery record")
+		fmt.Println("(see --recovery). Doesn't need the password.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	report, err := archiver.RepairArchive(fs.Arg(0), *dryRun)
+	if err != nil {
+		fatalf("Error repairing the archive: %v", err)
+	}
+
+	fmt.Printf("%d blocks checked, %d damaged\n", report.Blocks, report.Damaged)
+	switch {
+	case report.Unrecoverable > 0:
+		fmt.Printf("%d blocks could not be repaired; the archive is still damaged\n", report.Unrecoverable)
+		os.Exit(exitCorrupt)
+	case report.Damaged == 0:
+		fmt.Println("No damage found.")
+	case *dryRun:
+		fmt.Printf("All %d damaged blocks can be repaired.\n", report.Repaired)
+	default:
+		fmt.Printf("Repaired %d blocks.\n", report.Repaired)
+	}
+}
+
+func runSalvage(args []string) {
+	fs := flag.NewFlagSet("salvage", flag.ExitOnError)
+	addCredentialFlags(fs)
+	outputDir := fs.String("o", "", "Directory for recovered files (default <archive>-salvaged)")
+	fs.Usage = func() {
+		fmt.Println("Usage: seaf salvage --salt=<hex> [password options] [-o dir] archive.seaf")
+		fmt.Println()
+		fmt.Println("Scans a damaged archive for entries without relying on its index, writes")
+		fmt.Println("every intact entry to the output directory along with " + archiver.SalvageReportName + ",")
+		fmt.Println("and exits non-zero if anything was lost. Try repair first if the archive")
+		fmt.Println("has a recovery record.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if saltHex == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	archiveFile := fs.Arg(0)
+	if *outputDir == "" {
+		*outputDir = strings.TrimSuffix(archiveFile, filepath.Ext(archiveFile)) + "-salvaged"
+	}
+
+	pw, err := readPassword(false, len(keyFiles) > 0)
+	if err != nil {
+		fatalf("Error reading password: %v", err)
+	}
+	creds := archiver.Credentials{Password: pw, KeyFiles: keyFiles}
+	report, err := archiver.SalvageArchive(creds, saltHex, archiveFile, *outputDir)
+	creds.Wipe()
+	if err != nil {
+		fatalf("Error salvaging the archive: %v", err)
+	}
+
+	report.WriteTo(os.Stdout)
+	fmt.Printf("\nRecovered files and the report are in %s\n", *outputDir)
+	if !report.OK() {
+		os.Exit(exitCorrupt)
+	}
+}
+
+func runGenpass(args []string) {
+	fs := flag.NewFlagSet("genpass", flag.ExitOnError)
+	words := fs.Int("words", archiver.DefaultPassphraseWords, "Number of words")
+	separator := fs.String("separator", "-", "Text between words")
+	fs.Usage = func() {
+		fmt.Println("Usage: seaf genpass [--words=6] [--separator=-]")
+		fmt.Println()
+		fmt.Println("Prints a random passphrase from the EFF diceware wordlist.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
 
-		fmt.Printf("Archive successfully created: %s\n", fullOutputPath)
-		fmt.Println("Archiving and encryption have been completed successfully.")
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	passphrase, entropy, err := archiver.GeneratePassphrase(*words, *separator)
+	if err != nil {
+		fatalf("Error generating passphrase: %v", err)
 	}
+	os.Stdout.Write(append(passphrase, '\n'))
+	clear(passphrase)
+	fmt.Fprintf(os.Stderr, "Entropy: %.1f bits\n", entropy)
 }
 
-func createOutputDir() error {
-	if _, err := os.Stat("output"); os.IsNotExist(err) {
-		fmt.Println("Creating output directory...")
-		err := os.Mkdir("output", 0755)
+func runBenchKDF(args []string) {
+	fs := flag.NewFlagSet("bench-kdf", flag.ExitOnError)
+	maxTime := fs.Duration("max-time", 5*time.Second, "Stop after the first cost that takes longer than this")
+	maxMemory := fs.String("max-memory", "1G", "Skip costs that need more memory than this (at most 1G)")
+	fs.Usage = func() {
+		fmt.Println("Usage: seaf bench-kdf [--max-time=5s] [--max-memory=1G]")
+		fmt.Println()
+		fmt.Println("Times the key derivation at increasing costs on this machine.")
+		fmt.Println()
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	memoryLimit, err := parseSize(*maxMemory)
+	if err != nil {
+		fatalf("Invalid --max-memory: %v", err)
+	}
+	memoryLimit = min(memoryLimit, archiver.DefaultKDFMaxMemory)
+
+	fmt.Printf("%-24s %10s %12s\n", "Parameters", "Memory", "Time")
+	params := archiver.DefaultKDFParams
+	for params.LogN = 10; params.LogN <= 30 && params.Memory() <= memoryLimit; params.LogN++ {
+		elapsed, err := archiver.BenchmarkKDF(params)
 		if err != nil {
-			return fmt.Errorf("failed to create output directory: %v", err)
+			fatalf("Error running the key derivation: %v", err)
+		}
+		marker := ""
+		if params == archiver.DefaultKDFParams {
+			marker = "  (default)"
+		}
+		fmt.Printf("%-24s %10s %12s%s\n", params, formatBytes(params.Memory()), elapsed.Round(time.Millisecond), marker)
+		if elapsed > *maxTime {
+			break
+		}
+	}
+}
+
+// formatBytes prints a byte count with a binary unit.
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// checkPasswordPolicy exits if the password of a new archive is weaker than
+// --min-password-score allows. Key files add their own secret, so passwords
+// combined with them are not checked once the key files are known to be
+// long enough.
+func checkPasswordPolicy(creds archiver.Credentials, what string) {
+	for _, path := range creds.KeyFiles {
+		if _, err := archiver.KeyFileDigest(path); err != nil {
+			fatal(err)
+		}
+	}
+	if allowWeakPassword || len(creds.KeyFiles) > 0 {
+		return
+	}
+	minScore, err := archiver.ParseScore(minPasswordScore)
+	if err != nil {
+		fatal(err)
+	}
+	if err := archiver.CheckPasswordStrength(creds.Password, minScore); err != nil {
+		fatalf("Refusing %spassword: %v\nUse a longer passphrase, or --allow-weak-password to override.", what, err)
+	}
+}
+
+// verifySigner exits unless archiveFile carries a valid signature by signer.
+func verifySigner(archiveFile, signer string) {
+	if err := checkSigner(archiveFile, signer); err != nil {
+		fatal(err)
+	}
+}
+
+// checkSigner is verifySigner for callers that have to clean up first.
+func checkSigner(archiveFile, signer string) error {
+	publicKey, err := archiver.LoadPublicKey(signer)
+	if err != nil {
+		return fmt.Errorf("Error loading signer key: %w", err)
+	}
+
+	if err := archiver.VerifySignature(archiveFile, publicKey); err != nil {
+		return fmt.Errorf("Signature verification failed: %w", err)
+	}
+	infof("Good signature from %s\n", archiver.KeyFingerprint(publicKey))
+	return nil
+}
+
+// prepareOutput fails early, before any work is done, if the archive would
+// overwrite an existing one without --force or its directory is missing.
+// With --parents the directory is created.
+func prepareOutput(path string, volumes bool) error {
+	target := path
+	if volumes {
+		target = archiver.VolumePath(path, 1)
+	}
+	if !force {
+		if _, err := os.Lstat(target); err == nil {
+			return fmt.Errorf("%s already exists; use --force to overwrite it", target)
 		}
-		fmt.Println("Output directory created successfully")
-	} else if err != nil {
-		return fmt.Errorf("error checking output directory: %v", err)
+	}
+
+	dir := filepath.Dir(path)
+	if makeParents {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("Error creating output directory: %v", err)
+		}
+		return nil
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("output directory %s does not exist; use --parents to create it", dir)
 	}
 	return nil
 }
 
-func runGUI() {
+func runGUI(args []string) {
+	fs := flag.NewFlagSet("gui", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: seaf gui")
+		fmt.Println()
+		fmt.Println("Launches the graphical interface, as does running seaf without arguments.")
+	}
+	parseFlags(fs, args)
+
 	gui := ui.NewGUI()
 	gui.ShowAndRun()
 }
 
-func calculateEntropy(data []byte) float64 {
-	if len(data) == 0 {
-		return 0
+// printEntryStats prints how each file was stored and the totals, and adds
+// them to result.
+func printEntryStats(stats []archiver.EntryStats, padding archiver.PaddingPolicy, result *createResult) {
+	for _, st := range stats {
+		infof("\n--- Stored: %s ---\n", st.Path)
+		infof("Original size: %d bytes (%.2f MB)\n", st.OriginalSize, float64(st.OriginalSize)/(1024*1024))
+		infof("Data entropy: %.4f (0-1, the higher it is, the worse it shrinks)\n", st.Entropy)
+		infof("After compression: %d bytes, Ratio: %.2f%%\n", st.CompressedSize, percent(st.CompressedSize, st.OriginalSize))
+		if st.PaddingSize > 0 {
+			infof("Padding (%s): %+d bytes\n", padding, st.PaddingSize)
+		}
+		infof("After encryption: %d bytes\n", st.StoredSize)
+		infof("Total overhead: %+d bytes\n", int64(st.StoredSize)-int64(st.OriginalSize))
+		infof("Time: %s\n", st.Duration.Round(time.Millisecond))
+
+		result.OriginalSize += int64(st.OriginalSize)
+		result.CompressedSize += int64(st.CompressedSize)
+		result.PaddingSize += int64(st.PaddingSize)
+		result.EncryptedSize += int64(st.StoredSize)
+		result.Files = append(result.Files, createFile{
+			Path:           st.Path,
+			OriginalSize:   int64(st.OriginalSize),
+			CompressedSize: int64(st.CompressedSize),
+			PaddingSize:    int64(st.PaddingSize),
+			EncryptedSize:  int64(st.StoredSize),
+			Entropy:        st.Entropy,
+			Duration:       st.Duration.Seconds(),
+		})
 	}
 
-	var freq [256]int
-	for _, b := range data {
-		freq[b]++
+	original, compressed := uint64(result.OriginalSize), uint64(result.CompressedSize)
+	infof("\n=== FINAL RESULTS ===\n")
+	infof("Original total: %d bytes (%.2f MB)\n", result.OriginalSize, float64(result.OriginalSize)/(1024*1024))
+	infof("Compressed total: %d bytes (%.2f MB)\n", result.CompressedSize, float64(result.CompressedSize)/(1024*1024))
+	infof("Encrypted total: %d bytes (%.2f MB)\n", result.EncryptedSize, float64(result.EncryptedSize)/(1024*1024))
+	if result.PaddingSize > 0 {
+		infof("Padding total (%s): %d bytes (%.2f%% of compressed)\n", padding, result.PaddingSize, percent(uint64(result.PaddingSize), compressed))
 	}
+	infof("Final compression: %.2f%%\n", percent(compressed, original))
+	infof("Archive overhead: %.2f%%\n", float64(result.EncryptedSize-result.OriginalSize)/float64(max(result.OriginalSize, 1))*100)
+}
 
-	var entropy float64
-	for _, count := range freq {
-		if count > 0 {
-			p := float64(count) / float64(len(data))
-			entropy -= p * math.Log2(p)
-		}
+// percent returns part as a percentage of whole, or 0 if whole is 0.
+func percent(part, whole uint64) float64 {
+	if whole == 0 {
+		return 0
 	}
+	return float64(part) / float64(whole) * 100
+}
 
-	return entropy / 8.0
+// addCreateFlags registers the options for new archives.
+func addCreateFlags(fs *flag.FlagSet) {
+	fs.StringVar(&recoverySize, "recovery", "", "Add Reed-Solomon parity of this size for seaf repair, e.g. 5%")
+	fs.StringVar(&volumeSize, "volume-size", "", "Split the archive into volumes of at most this size, e.g. 2G (writes name.001, name.002, ...)")
+	fs.StringVar(&kdfTarget, "kdf-target", "", "Calibrate the key derivation to take about this long here, e.g. 1s (default: fixed cost)")
+	fs.StringVar(&minPasswordScore, "min-password-score", strconv.Itoa(archiver.DefaultMinPasswordScore), "Weakest password accepted for new archives: 0-4 or very weak, weak, fair, strong, very strong")
+	fs.BoolVar(&allowWeakPassword, "allow-weak-password", false, "Create the archive even if the password is weaker than --min-password-score")
+	fs.StringVar(&outputFile, "output", "archive.seaf", "Path of the archive to create")
+	fs.BoolVar(&force, "force", false, "Overwrite the archive if it already exists")
+	fs.BoolVar(&makeParents, "parents", false, "Create the missing parent directories of --output")
+	fs.BoolVar(&generateSalt, "generate-salt", false, "Generate a random salt")
+	fs.IntVar(&saltLength, "salt-length", 16, "Length of the generated salt in bytes")
+	fs.IntVar(&compressLevel, "compress", 6, "Compression level (0-9, where 0=no compression, 1=fastest, 9=best compression)")
+	fs.BoolVar(&optimizeImages, "optimize-images", false, "Optimize images by converting to a suitable format")
+	fs.Float64Var(&imageQuality, "quality", 75.0, "Image encoding quality (0-100)")
+	fs.BoolVar(&plainIndex, "plain-index", false, "Store file names and sizes unencrypted (still authenticated)")
+	fs.StringVar(&signKeyFile, "sign-key", "", "Ed25519 private key (PKCS#8 PEM) to sign the archive with")
+	fs.Var(&hiddenFiles, "hidden", "File to store in a hidden archive inside the free space (repeatable)")
+	fs.StringVar(&hiddenPassword, "hidden-password", "", "Password of the hidden archive (visible to other users; prefer the prompt)")
+	fs.StringVar(&hiddenPasswordFile, "hidden-password-file", "", "Read the hidden archive password from the first line of a file")
+	fs.StringVar(&hiddenPasswordEnv, "hidden-password-env", "", "Read the hidden archive password from the named environment variable")
+	fs.Var(&hiddenKeyFiles, "hidden-keyfile", "Key file of the hidden archive (repeatable)")
+	fs.BoolVar(&hiddenNoPassword, "hidden-no-password", false, "Use only the hidden key files, without a hidden archive password")
+	fs.StringVar(&reserveSize, "reserve", "", "Random free space to reserve after the data, e.g. 10M (holds the hidden archive; required with --hidden)")
+	fs.StringVar(&padding, "pad", "none", "Pad entries to hide exact sizes: none, pow2, padme or fixed:N")
+	fs.StringVar(&cipherName, "cipher", "auto", "Cipher: auto, aes-256-gcm or xchacha20-poly1305 (auto picks AES-GCM only with hardware AES)")
 }
 
+// init registers the flags of the deprecated form, which has both the
+// create and the extract options.
 func init() {
-	flag.StringVar(&password, "password", "", "Password for encryption/decryption")
-	flag.StringVar(&saltHex, "salt", "", "Salt (in hexadecimal format)")
-	flag.StringVar(&outputFile, "output", "archive.seaf", "The name of the output file to archive")
-	flag.BoolVar(&extract, "extract", false, "Extract files from the archive")
+	addCredentialFlags(flag.CommandLine)
+	addCreateFlags(flag.CommandLine)
+	flag.BoolVar(&extract, "extract", false, "Extract files from the archive (deprecated: use seaf extract)")
 	flag.StringVar(&archiveFile, "archive", "archive.seaf", "The name of the archive to extract")
-	flag.BoolVar(&generateSalt, "generate-salt", false, "Generate a random salt")
-	flag.IntVar(&saltLength, "salt-length", 16, "Length of the generated salt in bytes")
-	flag.IntVar(&compressLevel, "compress", 6, "Compression level (0-9, where 0=no compression, 1=fastest, 9=best compression)")
-	flag.BoolVar(&optimizeImages, "optimize-images", false, "Optimize images by converting to a suitable format")
-	flag.Float64Var(&imageQuality, "quality", 75.0, "Image encoding quality (0-100)")
+	flag.StringVar(&signerKey, "signer", "", "Ed25519 public key (PEM file or hex) the archive must be signed by before extraction")
+
+	flag.Usage = func() {
+		fmt.Println("Usage (deprecated): seaf [flags] file...")
+		fmt.Println("                    seaf [flags] --extract --archive=archive.seaf")
+		fmt.Println()
+		fmt.Println("Use 'seaf create' and 'seaf extract' instead; see 'seaf help'.")
+		fmt.Println()
+		fmt.Println("Flags:")
+		flag.PrintDefaults()
+	}
+}
 
-	asciiArt := `
+var asciiArt = `
               _____                    _____                    _____                    _____          
              /\    \                  /\    \                  /\    \                  /\    \         
             /::\    \                /::\    \                /::\    \                /::\    \        
@@ -228,35 +1133,29 @@ func init() {
                                                                                                         
 	`
 
-	flag.Usage = func() {
-		fmt.Println("Usage of S.E.A.F.:")
-		fmt.Println(asciiArt)
-		fmt.Println("Secure Archiver is a tool for encrypting and archiving files.")
-		fmt.Println()
-		fmt.Println("Run without arguments to launch GUI interface")
-		fmt.Println("Or use command line flags for terminal usage:")
-		fmt.Println()
-		fmt.Println("Flags:")
-		flag.PrintDefaults()
-		fmt.Println()
-		fmt.Println("Examples:")
-		fmt.Println("  Archive files:")
-		fmt.Println("    ", "./seaf", "--password=... --salt=... --output=archive.seaf file1 file2")
-		fmt.Println("    Output: ./output/archive.seaf")
-		fmt.Println()
-		fmt.Println("  Generate salt and archive files:")
-		fmt.Println("    ", "./seaf", "--password=... --generate-salt --salt-length=16 --output=archive.seaf file1 file2")
-		fmt.Println("    Output: ./output/archive.seaf")
-		fmt.Println()
-		fmt.Println("  Archive with image optimization:")
-		fmt.Println("    ", "./seaf", "--password=... --salt=... --optimize-images --quality=80 --output=archive.seaf image.jpg")
-		fmt.Println()
-		fmt.Println("  Extract files:")
-		fmt.Println("    ", "./seaf", "--password=... --salt=... --extract --archive=archive.seaf")
-		fmt.Println()
-		fmt.Println("  Launch GUI:")
-		fmt.Println("    ", "./seaf")
+// parseSize parses a byte count with an optional K, M, G or T suffix
+// (powers of 1024).
+func parseSize(s string) (uint64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	s = strings.TrimSuffix(s, "B")
+	if s == "" {
+		return 0, nil
+	}
+
+	multiplier := uint64(1)
+	if i := strings.IndexAny(s, "KMGT"); i == len(s)-1 {
+		multiplier = 1 << (10 * (strings.IndexByte("KMGT", s[i]) + 1))
+		s = s[:i]
+	}
+
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > math.MaxUint64/multiplier {
+		return 0, fmt.Errorf("size %q is too large", s)
 	}
+	return n * multiplier, nil
 }
 
 func generateRandomSalt(length int) (string, error) {
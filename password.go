@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var (
+	passwordFile  string
+	passwordEnv   string
+	passwordStdin bool
+	noPassword    bool
+
+	hiddenPasswordFile string
+	hiddenPasswordEnv  string
+	hiddenNoPassword   bool
+)
+
+// addCredentialFlags registers the salt, password and key file options, which
+// the archive flags and the subcommands that open archives share.
+func addCredentialFlags(fs *flag.FlagSet) {
+	fs.StringVar(&password, "password", "", "Password for encryption/decryption (visible to other users; prefer the prompt)")
+	fs.StringVar(&passwordFile, "password-file", "", "Read the password from the first line of a file")
+	fs.StringVar(&passwordEnv, "password-env", "", "Read the password from the named environment variable")
+	fs.BoolVar(&passwordStdin, "password-stdin", false, "Read the password from the first line of stdin")
+	fs.BoolVar(&noPassword, "no-password", false, "Use only the key files, without a password")
+	fs.StringVar(&saltHex, "salt", "", "Salt (in hexadecimal format)")
+	fs.Var(&keyFiles, "keyfile", "Key file mixed into key derivation (repeatable)")
+}
+
+var errEmptyPassword = errors.New("empty password refused")
+
+// readPassword returns the password from whichever source was selected on the
+// command line, or prompts for it without echo. With confirm set, a prompted
+// password has to be typed twice. It returns nil with --no-password, when
+// only key files are used.
+func readPassword(confirm bool, haveKeyFiles bool) ([]byte, error) {
+	sources := 0
+	for _, set := range []bool{password != "", passwordFile != "", passwordEnv != "", passwordStdin, noPassword} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, errors.New("use only one of --password, --password-file, --password-env, --password-stdin and --no-password")
+	}
+	if noPassword && !haveKeyFiles {
+		return nil, errors.New("--no-password needs at least one --keyfile")
+	}
+
+	var pw []byte
+	switch {
+	case password != "":
+		fmt.Fprintln(os.Stderr, "Warning: --password is visible in shell history and process listings; prefer the prompt or --password-file")
+		pw = []byte(password)
+		password = ""
+	case passwordFile != "":
+		var err error
+		if pw, err = passwordFromFile(passwordFile); err != nil {
+			return nil, err
+		}
+	case passwordEnv != "":
+		var err error
+		if pw, err = passwordFromEnv(passwordEnv); err != nil {
+			return nil, err
+		}
+	case passwordStdin:
+		line, err := bufio.NewReader(os.Stdin).ReadBytes('\n')
+		if err != nil && len(line) == 0 {
+			return nil, fmt.Errorf("reading password from stdin: %w", err)
+		}
+		pw = trimNewline(line)
+	case noPassword:
+		return nil, nil
+	default:
+		var err error
+		if pw, err = promptPassword("Password", confirm); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(pw) == 0 {
+		return nil, errEmptyPassword
+	}
+	return pw, nil
+}
+
+// readHiddenPassword is readPassword for the hidden archive of a new
+// archive, from --hidden-password, --hidden-password-file,
+// --hidden-password-env or a prompt, or nil with --hidden-no-password.
+func readHiddenPassword(haveKeyFiles bool) ([]byte, error) {
+	sources := 0
+	for _, set := range []bool{hiddenPassword != "", hiddenPasswordFile != "", hiddenPasswordEnv != "", hiddenNoPassword} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, errors.New("use only one of --hidden-password, --hidden-password-file, --hidden-password-env and --hidden-no-password")
+	}
+	if hiddenNoPassword && !haveKeyFiles {
+		return nil, errors.New("--hidden-no-password needs at least one --hidden-keyfile")
+	}
+
+	var pw []byte
+	var err error
+	switch {
+	case hiddenPassword != "":
+		fmt.Fprintln(os.Stderr, "Warning: --hidden-password is visible in shell history and process listings; prefer the prompt or --hidden-password-file")
+		pw = []byte(hiddenPassword)
+		hiddenPassword = ""
+	case hiddenPasswordFile != "":
+		pw, err = passwordFromFile(hiddenPasswordFile)
+	case hiddenPasswordEnv != "":
+		pw, err = passwordFromEnv(hiddenPasswordEnv)
+	case hiddenNoPassword:
+		return nil, nil
+	default:
+		pw, err = promptPassword("Hidden archive password", true)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pw) == 0 {
+		return nil, errEmptyPassword
+	}
+	return pw, nil
+}
+
+// passwordFromFile returns the first line of a file.
+func passwordFromFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading password file: %w", err)
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[:i+1]
+	}
+	return trimNewline(data), nil
+}
+
+// passwordFromEnv returns the value of an environment variable and removes
+// it, to keep it from child processes.
+func passwordFromEnv(name string) ([]byte, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	os.Unsetenv(name)
+	return []byte(value), nil
+}
+
+// promptPassword reads a password from the terminal without echo.
+func promptPassword(label string, confirm bool) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		// stdin may be carrying the archive; ask on the terminal instead.
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			return nil, errors.New("no password given and there is no terminal to ask on; use --password-file, --password-env or --password-stdin")
+		}
+		defer tty.Close()
+		fd = int(tty.Fd())
+	}
+
+	pw, err := readHidden(fd, label+": ")
+	if err != nil {
+		return nil, err
+	}
+	if len(pw) == 0 {
+		return nil, errEmptyPassword
+	}
+	if confirm {
+		again, err := readHidden(fd, "Confirm "+strings.ToLower(label)+": ")
+		if err != nil {
+			clear(pw)
+			return nil, err
+		}
+		match := bytes.Equal(pw, again)
+		clear(again)
+		if !match {
+			clear(pw)
+			return nil, errors.New("passwords do not match")
+		}
+	}
+	return pw, nil
+}
+
+func readHidden(fd int, prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	pw, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return pw, err
+}
+
+// trimNewline strips one trailing line ending, as left by echo or an editor.
+func trimNewline(b []byte) []byte {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	return bytes.TrimSuffix(b, []byte("\r"))
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+
+	"seaf/archiver"
+)
+
+// jsonSchemaVersion is the version of the --format=json output, documented
+// in the README. It changes when a field is removed or changes meaning;
+// fields may be added without a change.
+const jsonSchemaVersion = 1
+
+var outputFormat string
+
+// report collects the result of the running command. It is nil unless the
+// output format is json.
+var report *jsonReport
+
+type jsonReport struct {
+	Schema   int       `json:"schema"`
+	Command  string    `json:"command"`
+	OK       bool      `json:"ok"`
+	Error    string    `json:"error,omitempty"`
+	ExitCode int       `json:"exit_code"`
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration_seconds"`
+	Result   any       `json:"result,omitempty"`
+}
+
+var (
+	quiet     bool
+	verbose   bool
+	logFormat string
+)
+
+// parseFlags parses the options of a command, including the logging options
+// every command has, and sets up the logger.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	fs.BoolVar(&quiet, "q", false, "Only print results, warnings and errors")
+	fs.BoolVar(&verbose, "v", false, "Also log debug details")
+	fs.StringVar(&logFormat, "log-format", "text", "Log format on stderr: text or json")
+	fs.Parse(args)
+
+	level := slog.LevelInfo
+	if quiet {
+		level = slog.LevelWarn
+	}
+	if verbose {
+		level = slog.LevelDebug
+	}
+	switch logFormat {
+	case "text":
+		slog.SetLogLoggerLevel(level)
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	default:
+		fatalf("Invalid --log-format %q: use text or json", logFormat)
+	}
+}
+
+// infof prints a status message for people, such as progress or stats, to
+// stdout unless -q is given. Results and problems are printed regardless.
+func infof(format string, args ...any) {
+	if !quiet {
+		fmt.Printf(format, args...)
+	}
+}
+
+// addFormatFlags registers --format and its shorthand --json.
+func addFormatFlags(fs *flag.FlagSet) {
+	fs.StringVar(&outputFormat, "format", "text", "Output format: text or json")
+	fs.BoolFunc("json", "Short for --format=json", func(string) error {
+		outputFormat = "json"
+		return nil
+	})
+}
+
+// startReport is called once the flags are parsed. In json mode, the
+// messages meant for people go to stderr and stdout only gets the report.
+func startReport(command string) {
+	switch outputFormat {
+	case "text":
+		return
+	case "json":
+	default:
+		fatalf("Invalid --format %q: use text or json", outputFormat)
+	}
+	if os.Stdout != stdout {
+		fatal("--format=json can't be used while data is written to stdout")
+	}
+	report = &jsonReport{Schema: jsonSchemaVersion, Command: command, Started: time.Now().UTC()}
+	os.Stdout = os.Stderr
+}
+
+// finishReport writes the report with the result of the command. ok is
+// false if the command ran but found problems, e.g. bad entries.
+func finishReport(ok bool, result any) {
+	if report == nil {
+		return
+	}
+	report.OK = ok
+	if !ok {
+		report.ExitCode = exitCorrupt
+	}
+	report.Result = result
+	writeReport()
+}
+
+func writeReport() {
+	report.Duration = time.Since(report.Started).Seconds()
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		slog.Error("Error writing the report", "err", err)
+	}
+}
+
+// Exit codes, documented in the README. Errors from the archiver package
+// are told apart with errors.Is.
+const (
+	exitFailure       = 1 // any other error
+	exitUsage         = 2
+	exitWrongPassword = 3
+	exitCorrupt       = 4
+	exitUnsupported   = 5
+	exitNotArchive    = 6
+	exitUnsafePath    = 7
+	exitNotFound      = 8
+	exitBadSignature  = 9
+)
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, archiver.ErrUnsafePath):
+		return exitUnsafePath
+	case errors.Is(err, archiver.ErrWrongPassword):
+		return exitWrongPassword
+	case errors.Is(err, archiver.ErrUnsupportedVersion):
+		return exitUnsupported
+	case errors.Is(err, archiver.ErrNotArchive):
+		return exitNotArchive
+	case errors.Is(err, archiver.ErrBadSignature):
+		return exitBadSignature
+	case errors.Is(err, archiver.ErrCorrupt):
+		return exitCorrupt
+	case errors.Is(err, fs.ErrNotExist):
+		return exitNotFound
+	}
+	return exitFailure
+}
+
+// fatalf reports an error and exits with the code of the first error among
+// args. In json mode the error is also the report.
+func fatalf(format string, args ...any) {
+	exit(fmt.Sprintf(format, args...), argsExitCode(args))
+}
+
+func fatal(args ...any) {
+	exit(fmt.Sprint(args...), argsExitCode(args))
+}
+
+func argsExitCode(args []any) int {
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			return exitCode(err)
+		}
+	}
+	return exitFailure
+}
+
+func exit(msg string, code int) {
+	if report != nil {
+		report.Error = msg
+		report.ExitCode = code
+		writeReport()
+	}
+	slog.Error(msg)
+	os.Exit(code)
+}
+
+// The results of each command in the report.
+
+type createResult struct {
+	Archive string `json:"archive"`
+	// Salt is set only if it was generated, since it is needed to open
+	// the archive.
+	Salt           string       `json:"generated_salt,omitempty"`
+	Volumes        []string     `json:"volumes,omitempty"`
+	Files          []createFile `json:"files"`
+	OriginalSize   int64        `json:"original_size"`
+	CompressedSize int64        `json:"compressed_size"`
+	PaddingSize    int64        `json:"padding_size"`
+	EncryptedSize  int64        `json:"encrypted_size"`
+}
+
+type createFile struct {
+	Path           string  `json:"path"`
+	OriginalSize   int64   `json:"original_size"`
+	CompressedSize int64   `json:"compressed_size"`
+	PaddingSize    int64   `json:"padding_size"`
+	EncryptedSize  int64   `json:"encrypted_size"`
+	Entropy        float64 `json:"entropy"`
+	Duration       float64 `json:"duration_seconds"`
+}
+
+type extractResult struct {
+	Archive   string        `json:"archive"`
+	OutputDir string        `json:"output_dir"`
+	Files     []extractFile `json:"files"`
+}
+
+type extractFile struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Size uint64 `json:"size"`
+}
+
+type listResult struct {
+	Archive string      `json:"archive"`
+	Entries []listEntry `json:"entries"`
+}
+
+type listEntry struct {
+	Name        string `json:"name"`
+	Size        uint64 `json:"size"`
+	StoredSize  uint64 `json:"stored_size"`
+	Compression string `json:"compression"`
+	// SHA256 is empty for archives with a plain index.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+type testResult struct {
+	Archive string      `json:"archive"`
+	Entries []testEntry `json:"entries"`
+	Bad     int         `json:"bad"`
+	// Error is set if the archive as a whole failed a check.
+	Error string `json:"archive_error,omitempty"`
+}
+
+type testEntry struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type infoResult struct {
+	Archive        string        `json:"archive"`
+	FormatVersion  uint16        `json:"format_version"`
+	KDF            string        `json:"kdf"`
+	KDFMemory      uint64        `json:"kdf_memory"`
+	Cipher         string        `json:"cipher"`
+	EncryptedIndex bool          `json:"encrypted_index"`
+	KeyFiles       bool          `json:"key_files"`
+	Padded         bool          `json:"padded"`
+	Signed         bool          `json:"signed"`
+	Solid          bool          `json:"solid"`
+	Volumes        int           `json:"volumes"`
+	RecoverySize   int64         `json:"recovery_size"`
+	Size           int64         `json:"size"`
+	Contents       *infoContents `json:"contents,omitempty"`
+}
+
+type infoContents struct {
+	Entries       int            `json:"entries"`
+	OriginalSize  uint64         `json:"original_size"`
+	StoredSize    uint64         `json:"stored_size"`
+	Compression   map[string]int `json:"compression"`
+	Created       *time.Time     `json:"created,omitempty"`
+	Creator       string         `json:"creator,omitempty"`
+	Authenticated bool           `json:"authenticated"`
+}
+
+func newInfoResult(archive string, info *archiver.ArchiveInfo, contents *archiver.ContentsInfo, authenticated bool) infoResult {
+	h := info.Header
+	r := infoResult{
+		Archive:        archive,
+		FormatVersion:  h.Version,
+		KDF:            h.KDF.String(),
+		KDFMemory:      h.KDF.Memory(),
+		Cipher:         h.Cipher.String(),
+		EncryptedIndex: h.EncryptedIndex(),
+		KeyFiles:       h.KeyFiles(),
+		Padded:         h.Padded(),
+		Signed:         h.Signed(),
+		Volumes:        info.Volumes,
+		RecoverySize:   info.RecoverySize,
+		Size:           info.Size,
+	}
+	if contents != nil {
+		r.Contents = &infoContents{
+			Entries:       contents.Entries,
+			OriginalSize:  contents.OriginalSize,
+			StoredSize:    contents.StoredSize,
+			Compression:   contents.Compression,
+			Creator:       contents.Meta.Creator,
+			Authenticated: authenticated,
+		}
+		if !contents.Meta.Created.IsZero() {
+			r.Contents.Created = &contents.Meta.Created
+		}
+	}
+	return r
+}
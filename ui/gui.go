@@ -5,7 +5,6 @@ import (
 	_ "embed"
 	"encoding/hex"
 	"fmt"
-	"math"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -31,6 +30,8 @@ type GUI struct {
 	window                 fyne.Window
 	mainTabs               *container.AppTabs
 	passwordEntry          *widget.Entry
+	passwordStrengthBar    *widget.ProgressBar
+	minScoreSelect         *widget.Select
 	saltEntry              *widget.Entry
 	filesList              *widget.List
 	outputEntry            *widget.Entry
@@ -41,9 +42,11 @@ type GUI struct {
 	extractSaltEntry       *widget.Entry
 	archivePathLabel       *widget.Label
 	extractBtn             *widget.Button
+	verifyBtn              *widget.Button
 	selectedArchive        string
 	progressBar            *widget.ProgressBar
 	progressLabel          *widget.Label
+	resultsTitle           *widget.Label
 	resultsText            *widget.Label
 	resultsContainer       *fyne.Container
 	closeResultsBtn        *widget.Button
@@ -53,11 +56,18 @@ type GUI struct {
 	outputDir              string
 	optimizeImagesCheck    *widget.Check
 	imageQualityEntry      *widget.Entry
+	encryptIndexCheck      *widget.Check
+	paddingSelect          *widget.Select
+	keyFiles               []string
+	keyFilesLabel          *widget.Label
+	extractKeyFiles        []string
+	extractKeyFilesLabel   *widget.Label
 }
 
 type Statistics struct {
 	OriginalSize     int64
 	CompressedSize   int64
+	PaddingSize      int64
 	EncryptedSize    int64
 	Entropy          float64
 	CompressionRatio float64
@@ -68,6 +78,7 @@ type FileStat struct {
 	Filename         string
 	OriginalSize     int64
 	CompressedSize   int64
+	PaddingSize      int64
 	EncryptedSize    int64
 	Entropy          float64
 	CompressionRatio float64
@@ -118,8 +129,9 @@ func (g *GUI) createUI() {
 	g.closeResultsBtn.Importance = widget.LowImportance
 	g.closeResultsBtn.Hide()
 
+	g.resultsTitle = widget.NewLabel("Compression Results")
 	resultsHeader := container.NewHBox(
-		widget.NewLabel("Compression Results"),
+		g.resultsTitle,
 		container.NewHBox(),
 		g.closeResultsBtn,
 	)
@@ -163,6 +175,39 @@ func (g *GUI) createArchiveTab() *container.Scroll {
 	g.passwordEntry = widget.NewPasswordEntry()
 	g.passwordEntry.SetPlaceHolder("Enter encryption password")
 
+	var strength archiver.PasswordStrength
+	g.passwordStrengthBar = widget.NewProgressBar()
+	g.passwordStrengthBar.Max = 4
+	g.passwordStrengthBar.TextFormatter = func() string {
+		if g.passwordEntry.Text == "" {
+			return "Strength: -"
+		}
+		return "Strength: " + strength.String()
+	}
+	g.passwordEntry.OnChanged = func(text string) {
+		strength = archiver.EstimateStrength([]byte(text))
+		g.passwordStrengthBar.SetValue(float64(strength.Score))
+	}
+	generatePassBtn := widget.NewButton("Generate", g.generatePassphrase)
+	passwordCopyBtn := widget.NewButtonWithIcon("", theme.ContentCopyIcon(), func() {
+		if g.passwordEntry.Text != "" {
+			g.copyToClipboard(g.passwordEntry.Text)
+		}
+	})
+	passwordCopyBtn.Importance = widget.LowImportance
+
+	passwordContainer := container.NewVBox(
+		container.NewBorder(nil, nil, nil, container.NewHBox(generatePassBtn, passwordCopyBtn), g.passwordEntry),
+		g.passwordStrengthBar,
+	)
+
+	scores := make([]string, 5)
+	for i := range scores {
+		scores[i] = archiver.ScoreLabel(i)
+	}
+	g.minScoreSelect = widget.NewSelect(scores, nil)
+	g.minScoreSelect.SetSelected(archiver.ScoreLabel(archiver.DefaultMinPasswordScore))
+
 	g.saltEntry = widget.NewEntry()
 	g.saltEntry.SetPlaceHolder("Hex salt or generate new")
 
@@ -204,6 +249,15 @@ func (g *GUI) createArchiveTab() *container.Scroll {
 	g.imageQualityEntry.SetPlaceHolder("Quality (0-100)")
 	g.imageQualityEntry.Disable()
 
+	g.keyFilesLabel = widget.NewLabel("")
+	keyFilesContainer := g.keyFilePicker(&g.keyFiles, g.keyFilesLabel)
+
+	g.paddingSelect = widget.NewSelect([]string{"none", "pow2", "padme"}, nil)
+	g.paddingSelect.SetSelected("none")
+
+	g.encryptIndexCheck = widget.NewCheck("Hide file names and sizes", nil)
+	g.encryptIndexCheck.SetChecked(true)
+
 	g.filesList = widget.NewList(
 		func() int {
 			return len(g.selectedFiles)
@@ -257,11 +311,15 @@ func (g *GUI) createArchiveTab() *container.Scroll {
 
 	form := &widget.Form{
 		Items: []*widget.FormItem{
-			{Text: "Password", Widget: g.passwordEntry},
+			{Text: "Password", Widget: passwordContainer},
+			{Text: "Minimum Strength", Widget: g.minScoreSelect},
+			{Text: "Key Files", Widget: keyFilesContainer},
 			{Text: "Salt", Widget: saltContainer},
 			{Text: "Compression Level", Widget: g.compressionLevelSelect},
 			{Text: "Image Optimization", Widget: g.optimizeImagesCheck},
 			{Text: "Image Quality", Widget: g.imageQualityEntry},
+			{Text: "Encrypt Metadata", Widget: g.encryptIndexCheck},
+			{Text: "Size Padding", Widget: g.paddingSelect},
 			{Text: "Files", Widget: filesContainer},
 			{Text: "Save Location", Widget: folderContainer},
 			{Text: "Output File", Widget: g.outputEntry},
@@ -283,6 +341,9 @@ func (g *GUI) createExtractTab() *container.Scroll {
 	g.extractSaltEntry = widget.NewEntry()
 	g.extractSaltEntry.SetPlaceHolder("Hex salt used for encryption")
 
+	g.extractKeyFilesLabel = widget.NewLabel("")
+	keyFilesContainer := g.keyFilePicker(&g.extractKeyFiles, g.extractKeyFilesLabel)
+
 	g.archivePathLabel = widget.NewLabel("No archive selected")
 	g.archivePathLabel.Wrapping = fyne.TextWrapWord
 
@@ -291,9 +352,12 @@ func (g *GUI) createExtractTab() *container.Scroll {
 	g.extractBtn = widget.NewButton("Extract Archive", g.extractArchive)
 	g.extractBtn.Importance = widget.HighImportance
 
+	g.verifyBtn = widget.NewButton("Verify", g.verifyArchive)
+
 	form := &widget.Form{
 		Items: []*widget.FormItem{
 			{Text: "Password", Widget: g.extractPasswordEntry},
+			{Text: "Key Files", Widget: keyFilesContainer},
 			{Text: "Salt", Widget: g.extractSaltEntry},
 			{Text: "Archive File", Widget: container.NewVBox(
 				g.archivePathLabel,
@@ -306,10 +370,58 @@ func (g *GUI) createExtractTab() *container.Scroll {
 		widget.NewLabel("Extract files from encrypted archive"),
 		widget.NewSeparator(),
 		form,
-		g.extractBtn,
+		container.NewGridWithColumns(2, g.extractBtn, g.verifyBtn),
 	))
 }
 
+// keyFilePicker lets the user add any number of key files to files and shows
+// their names in label.
+func (g *GUI) keyFilePicker(files *[]string, label *widget.Label) fyne.CanvasObject {
+	label.Wrapping = fyne.TextWrapWord
+	updateLabel := func() {
+		if len(*files) == 0 {
+			label.SetText("No key files")
+			return
+		}
+		names := make([]string, len(*files))
+		for i, f := range *files {
+			names[i] = filepath.Base(f)
+		}
+		label.SetText(strings.Join(names, ", "))
+	}
+	updateLabel()
+
+	addBtn := widget.NewButton("Add Key File", func() {
+		dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, g.window)
+				return
+			}
+			if reader == nil {
+				return
+			}
+			defer reader.Close()
+
+			path := reader.URI().Path()
+			for _, existing := range *files {
+				if existing == path {
+					return
+				}
+			}
+			*files = append(*files, path)
+			updateLabel()
+		}, g.window)
+	})
+
+	clearBtn := widget.NewButton("Clear", func() {
+		*files = nil
+		updateLabel()
+	})
+	clearBtn.Importance = widget.LowImportance
+
+	return container.NewVBox(label, container.NewHBox(addBtn, clearBtn))
+}
+
 func (g *GUI) selectSaveFolder() {
 	dialog.ShowFolderOpen(func(uri fyne.ListableURI, err error) {
 		if err != nil {
@@ -336,6 +448,31 @@ func (g *GUI) generateSalt() {
 	g.extractSaltEntry.SetText(salt)
 }
 
+// generatePassphrase fills in a diceware passphrase and shows it once, since
+// the password field hides it.
+func (g *GUI) generatePassphrase() {
+	passphrase, entropy, err := archiver.GeneratePassphrase(archiver.DefaultPassphraseWords, "-")
+	if err != nil {
+		dialog.ShowError(err, g.window)
+		return
+	}
+	text := string(passphrase)
+	clear(passphrase)
+	g.passwordEntry.SetText(text)
+
+	passphraseLabel := widget.NewLabel(text)
+	passphraseLabel.TextStyle = fyne.TextStyle{Monospace: true}
+	copyBtn := widget.NewButtonWithIcon("Copy", theme.ContentCopyIcon(), func() {
+		g.copyToClipboard(text)
+	})
+	content := container.NewVBox(
+		passphraseLabel,
+		widget.NewLabel(fmt.Sprintf("Entropy: %.1f bits. Write it down or store it in a password manager.", entropy)),
+		copyBtn,
+	)
+	dialog.ShowCustom("Generated Passphrase", "Close", content, g.window)
+}
+
 func (g *GUI) selectFiles() {
 	dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
 		if err != nil {
@@ -439,10 +576,17 @@ func (g *GUI) selectArchive() {
 }
 
 func (g *GUI) createArchive() {
-	if g.passwordEntry.Text == "" {
-		dialog.ShowInformation("Validation Error", "Please enter password", g.window)
+	if g.passwordEntry.Text == "" && len(g.keyFiles) == 0 {
+		dialog.ShowInformation("Validation Error", "Please enter password or add a key file", g.window)
 		return
 	}
+	if g.passwordEntry.Text != "" && len(g.keyFiles) == 0 {
+		minScore, _ := archiver.ParseScore(g.minScoreSelect.Selected)
+		if err := archiver.CheckPasswordStrength([]byte(g.passwordEntry.Text), minScore); err != nil {
+			dialog.ShowInformation("Weak Password", err.Error()+"\nUse a longer passphrase or lower the minimum strength.", g.window)
+			return
+		}
+	}
 	if g.saltEntry.Text == "" {
 		dialog.ShowInformation("Validation Error", "Please enter salt", g.window)
 		return
@@ -504,34 +648,51 @@ func (g *GUI) createArchive() {
 			return
 		}
 
-		stats, err := g.calculateStatistics(files, g.passwordEntry.Text, g.saltEntry.Text, compressLevel, optimize, float32(quality))
-		if err != nil {
-			g.showError(fmt.Sprintf("Error calculating statistics: %v", err))
-			return
+		creds := archiver.Credentials{Password: []byte(g.passwordEntry.Text), KeyFiles: g.keyFiles}
+		defer creds.Wipe()
+
+		padding, _ := archiver.ParsePaddingPolicy(g.paddingSelect.Selected)
+		opts := archiver.ArchiveOptions{
+			CompressLevel:  compressLevel,
+			OptimizeImages: optimize,
+			ImageQuality:   float32(quality),
+			PlainIndex:     !g.encryptIndexCheck.Checked,
+			Padding:        padding,
+			Overwrite:      true,
 		}
 
-		err = archiver.CreateArchive(g.passwordEntry.Text, g.saltEntry.Text, fullOutputPath, files, compressLevel, optimize, float32(quality))
+		entryStats, err := archiver.CreateArchive(creds, g.saltEntry.Text, fullOutputPath, files, opts)
 		if err != nil {
 			g.showError(fmt.Sprintf("Error creating archive: %v", err))
 			return
 		}
 
-		g.showResults(stats, fullOutputPath)
+		fyne.Do(func() { g.passwordEntry.SetText("") })
+		g.showResults(newStatistics(entryStats), fullOutputPath)
 		g.showSuccess("Archive created successfully!")
 	}()
 }
 
-func (g *GUI) extractArchive() {
-	if g.extractPasswordEntry.Text == "" {
-		dialog.ShowInformation("Validation Error", "Please enter password", g.window)
-		return
+// validateExtractForm reports whether the extract tab has everything needed
+// to open the selected archive.
+func (g *GUI) validateExtractForm() bool {
+	if g.extractPasswordEntry.Text == "" && len(g.extractKeyFiles) == 0 {
+		dialog.ShowInformation("Validation Error", "Please enter password or add a key file", g.window)
+		return false
 	}
 	if g.extractSaltEntry.Text == "" {
 		dialog.ShowInformation("Validation Error", "Please enter salt", g.window)
-		return
+		return false
 	}
 	if g.selectedArchive == "" {
 		dialog.ShowInformation("Validation Error", "Please select archive file", g.window)
+		return false
+	}
+	return true
+}
+
+func (g *GUI) extractArchive() {
+	if !g.validateExtractForm() {
 		return
 	}
 
@@ -543,17 +704,51 @@ func (g *GUI) extractArchive() {
 
 		archiveDir := filepath.Dir(g.selectedArchive)
 
-		err := archiver.ExtractArchive(g.extractPasswordEntry.Text,
-			g.extractSaltEntry.Text, g.selectedArchive, archiveDir)
+		creds := archiver.Credentials{Password: []byte(g.extractPasswordEntry.Text), KeyFiles: g.extractKeyFiles}
+		defer creds.Wipe()
+
+		_, err := archiver.ExtractArchive(creds, g.extractSaltEntry.Text, g.selectedArchive, archiveDir)
 		if err != nil {
 			g.showError(fmt.Sprintf("Error extracting archive: %v", err))
 			return
 		}
 
+		fyne.Do(func() { g.extractPasswordEntry.SetText("") })
 		g.showSuccess(fmt.Sprintf("Archive extracted successfully to: %s", archiveDir))
 	}()
 }
 
+// verifyArchive checks every entry of the selected archive without writing
+// anything.
+func (g *GUI) verifyArchive() {
+	if !g.validateExtractForm() {
+		return
+	}
+
+	g.showProgress("Verifying archive...")
+	g.clearResults()
+
+	go func() {
+		defer g.hideProgress()
+
+		creds := archiver.Credentials{Password: []byte(g.extractPasswordEntry.Text), KeyFiles: g.extractKeyFiles}
+		defer creds.Wipe()
+
+		report, err := archiver.TestArchive(creds, g.extractSaltEntry.Text, g.selectedArchive)
+		if err != nil {
+			g.showError(fmt.Sprintf("Error opening archive: %v", err))
+			return
+		}
+
+		g.showTestReport(report)
+		if !report.OK() {
+			g.showError(fmt.Sprintf("Archive is damaged: %d of %d entries bad", len(report.Bad), len(report.Entries)))
+			return
+		}
+		g.showSuccess(fmt.Sprintf("All %d entries are intact", len(report.Entries)))
+	}()
+}
+
 func (g *GUI) getSelectedCompressionLevel() int {
 	selected := g.compressionLevelSelect.Selected
 	if selected == "" {
@@ -567,67 +762,37 @@ func (g *GUI) getSelectedCompressionLevel() int {
 	return level
 }
 
-func (g *GUI) calculateStatistics(files []archiver.FileInfo, password, saltHex string, compressLevel int, optimizeImages bool, imageQuality float32) (*Statistics, error) {
+// newStatistics sums up how CreateArchive stored the files.
+func newStatistics(entries []archiver.EntryStats) *Statistics {
 	stats := &Statistics{
-		FileStats: make([]FileStat, 0, len(files)),
-	}
-
-	salt, err := hex.DecodeString(saltHex)
-	if err != nil {
-		return nil, err
-	}
-
-	key, err := archiver.GenerateKey(password, salt)
-	if err != nil {
-		return nil, err
+		FileStats: make([]FileStat, 0, len(entries)),
 	}
 
-	for _, file := range files {
-		data, err := os.ReadFile(file.Path)
-		if err != nil {
-			return nil, err
-		}
-
-		if optimizeImages {
-			optData, changed, err := archiver.OptimizeImage(data, file.Path, imageQuality)
-			if err == nil && changed {
-				data = optData
-			}
-		}
-
-		compressedData, err := archiver.Compress(data, compressLevel)
-		if err != nil {
-			return nil, err
-		}
-
-		encryptedData, err := archiver.Encrypt(compressedData, key)
-		if err != nil {
-			return nil, err
-		}
-
-		entropy := calculateEntropy(data)
-		compressionRatio := float64(len(compressedData)) / float64(len(data)) * 100
-
+	for _, e := range entries {
 		fileStat := FileStat{
-			Filename:         filepath.Base(file.Path),
-			OriginalSize:     int64(len(data)),
-			CompressedSize:   int64(len(compressedData)),
-			EncryptedSize:    int64(len(encryptedData)),
-			Entropy:          entropy,
-			CompressionRatio: compressionRatio,
+			Filename:       e.Name,
+			OriginalSize:   int64(e.OriginalSize),
+			CompressedSize: int64(e.CompressedSize),
+			PaddingSize:    int64(e.PaddingSize),
+			EncryptedSize:  int64(e.StoredSize),
+			Entropy:        e.Entropy,
+		}
+		if e.OriginalSize > 0 {
+			fileStat.CompressionRatio = float64(e.CompressedSize) / float64(e.OriginalSize) * 100
 		}
 
 		stats.FileStats = append(stats.FileStats, fileStat)
-		stats.OriginalSize += int64(len(data))
-		stats.CompressedSize += int64(len(compressedData))
-		stats.EncryptedSize += int64(len(encryptedData))
+		stats.OriginalSize += fileStat.OriginalSize
+		stats.CompressedSize += fileStat.CompressedSize
+		stats.PaddingSize += fileStat.PaddingSize
+		stats.EncryptedSize += fileStat.EncryptedSize
 	}
 
 	if stats.OriginalSize > 0 {
 		stats.CompressionRatio = float64(stats.CompressedSize) / float64(stats.OriginalSize) * 100
 	}
 
-	return stats, nil
+	return stats
 }
 
 func (g *GUI) showResults(stats *Statistics, outputPath string) {
@@ -645,6 +810,9 @@ func (g *GUI) showResults(stats *Statistics, outputPath string) {
 		result.WriteString(fmt.Sprintf("📁 %s\n", fileStat.Filename))
 		result.WriteString(fmt.Sprintf("   Original: %s\n", formatFileSize(fileStat.OriginalSize)))
 		result.WriteString(fmt.Sprintf("   Compressed: %s (%.2f%%)\n", formatFileSize(fileStat.CompressedSize), fileStat.CompressionRatio))
+		if fileStat.PaddingSize > 0 {
+			result.WriteString(fmt.Sprintf("   Padding: +%s\n", formatFileSize(fileStat.PaddingSize)))
+		}
 		result.WriteString(fmt.Sprintf("   Encrypted: %s\n", formatFileSize(fileStat.EncryptedSize)))
 		result.WriteString(fmt.Sprintf("   Entropy: %.4f\n\n", fileStat.Entropy))
 	}
@@ -654,6 +822,10 @@ func (g *GUI) showResults(stats *Statistics, outputPath string) {
 		formatFileSize(stats.OriginalSize), float64(stats.OriginalSize)/(1024*1024)))
 	result.WriteString(fmt.Sprintf("Compressed total: %s (%.2f MB)\n",
 		formatFileSize(stats.CompressedSize), float64(stats.CompressedSize)/(1024*1024)))
+	if stats.PaddingSize > 0 {
+		result.WriteString(fmt.Sprintf("Padding total (%s): %s (%.2f%% of compressed)\n", g.paddingSelect.Selected,
+			formatFileSize(stats.PaddingSize), float64(stats.PaddingSize)/float64(stats.CompressedSize)*100))
+	}
 	result.WriteString(fmt.Sprintf("Encrypted total: %s (%.2f MB)\n",
 		formatFileSize(stats.EncryptedSize), float64(stats.EncryptedSize)/(1024*1024)))
 	result.WriteString(fmt.Sprintf("Final compression: %.2f%%\n", stats.CompressionRatio))
@@ -662,6 +834,33 @@ func (g *GUI) showResults(stats *Statistics, outputPath string) {
 	result.WriteString(fmt.Sprintf("Output file: %s\n", outputPath))
 
 	fyne.Do(func() {
+		g.resultsTitle.SetText("Compression Results")
+		g.resultsText.SetText(result.String())
+		g.resultsContainer.Show()
+		g.closeResultsBtn.Show()
+	})
+}
+
+func (g *GUI) showTestReport(report *archiver.TestReport) {
+	var result strings.Builder
+	bad := make(map[int]error)
+	for _, e := range report.Bad {
+		bad[e.Index] = e.Err
+	}
+	for i, entry := range report.Entries {
+		if err, ok := bad[i]; ok {
+			result.WriteString(fmt.Sprintf("❌ %s: %v\n", entry.Name, err))
+		} else {
+			result.WriteString(fmt.Sprintf("✅ %s (%s)\n", entry.Name, formatFileSize(int64(entry.OriginalSize))))
+		}
+	}
+	if report.Err != nil {
+		result.WriteString(fmt.Sprintf("\nArchive check failed: %v\n", report.Err))
+	}
+	result.WriteString(fmt.Sprintf("\n%d entries, %d bad\n", len(report.Entries), len(report.Bad)))
+
+	fyne.Do(func() {
+		g.resultsTitle.SetText("Verification Results")
 		g.resultsText.SetText(result.String())
 		g.resultsContainer.Show()
 		g.closeResultsBtn.Show()
@@ -708,6 +907,7 @@ func (g *GUI) showProgress(message string) {
 
 		g.createArchiveBtn.Disable()
 		g.extractBtn.Disable()
+		g.verifyBtn.Disable()
 	})
 }
 
@@ -718,6 +918,7 @@ func (g *GUI) hideProgress() {
 
 		g.createArchiveBtn.Enable()
 		g.extractBtn.Enable()
+		g.verifyBtn.Enable()
 	})
 }
 
@@ -746,27 +947,6 @@ func generateRandomSalt(length int) (string, error) {
 	return hex.EncodeToString(salt), nil
 }
 
-func calculateEntropy(data []byte) float64 {
-	if len(data) == 0 {
-		return 0
-	}
-
-	var freq [256]int
-	for _, b := range data {
-		freq[b]++
-	}
-
-	var entropy float64
-	for _, count := range freq {
-		if count > 0 {
-			p := float64(count) / float64(len(data))
-			entropy -= p * math.Log2(p)
-		}
-	}
-
-	return entropy / 8.0
-}
-
 func formatFileSize(bytes int64) string {
 	if bytes == 0 {
 		return "0 B"